@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5"
+)
+
+// ---- ADMIN ----
+
+const testConnectionTimeout = 5 * time.Second
+
+// requireScope guards an endpoint that needs at least scope. Admin
+// endpoints stay closed while authentication is off, since anyone would
+// otherwise be admin.
+func requireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(keyScopes) == 0 && scope == scopeAdmin {
+			respondJSON(w, r, http.StatusForbidden, ErrorResponse{
+				Error:   "Forbidden",
+				Message: "admin endpoints require API keys to be configured",
+			})
+			return
+		}
+		if scopeRank[callerScope(r)] < scopeRank[scope] {
+			respondJSON(w, r, http.StatusForbidden, ErrorResponse{
+				Error:     "Forbidden",
+				Message:   "this endpoint requires " + scope + " scope",
+				RequestID: requestID(r),
+			})
+			return
+		}
+		next(w, r)
+	}
+}
+
+type TestConnectionRequest struct {
+	DSN    string `json:"dsn"`
+	Driver string `json:"driver,omitempty"` // defaults to DB_DRIVER
+}
+
+type TestConnectionResponse struct {
+	OK        bool   `json:"ok"`
+	Target    string `json:"target,omitempty"`
+	Error     string `json:"error,omitempty"`
+	LatencyMs int64  `json:"latencyMs"`
+}
+
+// testConnectionHandler opens a throwaway pool for a candidate DSN, pings
+// it and reports the outcome along with the target, minus credentials.
+func testConnectionHandler(w http.ResponseWriter, r *http.Request) {
+	var req TestConnectionRequest
+	if berr := decodeJSONBody(w, r, &req); berr != nil {
+		respondJSON(w, r, berr.status, ErrorResponse{
+			Error: berr.msg,
+		})
+		return
+	}
+	if req.DSN == "" {
+		respondJSON(w, r, http.StatusBadRequest, ErrorResponse{
+			Error: "dsn is required",
+		})
+		return
+	}
+	driver := req.Driver
+	if driver == "" {
+		driver = dbDriver
+	}
+
+	target, err := describeDSN(driver, req.DSN)
+	if err != nil {
+		respondJSON(w, r, http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid dsn",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	pool, err := sql.Open(sqlDriverName(driver), req.DSN)
+	if err != nil {
+		respondJSON(w, r, http.StatusOK, TestConnectionResponse{Target: target, Error: err.Error()})
+		return
+	}
+	defer pool.Close()
+
+	ctx, cancel := context.WithTimeout(r.Context(), testConnectionTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err = pool.PingContext(ctx)
+	resp := TestConnectionResponse{
+		OK:        err == nil,
+		Target:    target,
+		LatencyMs: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	respondJSON(w, r, http.StatusOK, resp)
+}
+
+// describeDSN renders user@address/database for a DSN, leaving out the
+// password and any parameters.
+func describeDSN(driver, dsn string) (string, error) {
+	switch driver {
+	case driverMySQL:
+		cfg, err := mysql.ParseDSN(dsn)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s@%s(%s)/%s", cfg.User, cfg.Net, cfg.Addr, cfg.DBName), nil
+	case driverPostgres:
+		cfg, err := pgx.ParseConfig(dsn)
+		if err != nil {
+			return "", fmt.Errorf("cannot parse postgres dsn")
+		}
+		return fmt.Sprintf("%s@%s:%d/%s", cfg.User, cfg.Host, cfg.Port, cfg.Database), nil
+	}
+	return "", fmt.Errorf("driver must be %s or %s", driverMySQL, driverPostgres)
+}
+
+// PoolDetail is sql.DBStats for /admin/pool, with durations in
+// milliseconds.
+type PoolDetail struct {
+	MaxOpenConnections int   `json:"maxOpenConnections"`
+	OpenConnections    int   `json:"openConnections"`
+	InUse              int   `json:"inUse"`
+	Idle               int   `json:"idle"`
+	WaitCount          int64 `json:"waitCount"`
+	WaitDurationMs     int64 `json:"waitDurationMs"`
+	MaxIdleClosed      int64 `json:"maxIdleClosed"`
+	MaxIdleTimeClosed  int64 `json:"maxIdleTimeClosed"`
+	MaxLifetimeClosed  int64 `json:"maxLifetimeClosed"`
+}
+
+func poolDetail(pool *sql.DB) PoolDetail {
+	st := pool.Stats()
+	return PoolDetail{
+		MaxOpenConnections: st.MaxOpenConnections,
+		OpenConnections:    st.OpenConnections,
+		InUse:              st.InUse,
+		Idle:               st.Idle,
+		WaitCount:          st.WaitCount,
+		WaitDurationMs:     st.WaitDuration.Milliseconds(),
+		MaxIdleClosed:      st.MaxIdleClosed,
+		MaxIdleTimeClosed:  st.MaxIdleTimeClosed,
+		MaxLifetimeClosed:  st.MaxLifetimeClosed,
+	}
+}
+
+// poolHandler reports the full connection pool statistics of the primary
+// and, when configured, the read pool.
+func poolHandler(w http.ResponseWriter, r *http.Request) {
+	pools := map[string]PoolDetail{"primary": poolDetail(db)}
+	if readDB != nil {
+		pools["read"] = poolDetail(readDB)
+	}
+	respondJSON(w, r, http.StatusOK, pools)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// ---- ADMISSION ----
+
+var (
+	errQueueFull    = errors.New("request queue is full")
+	errQueueTimeout = errors.New("timed out waiting for a free slot")
+)
+
+// admission caps concurrent DB-bound requests. Requests beyond the cap
+// wait in a bounded queue; a nil admission admits everything.
+type admission struct {
+	slots    chan struct{}
+	waiting  atomic.Int64
+	maxQueue int64
+	timeout  time.Duration
+}
+
+var admit = newAdmission(maxConcurrency, queueSize, queueTimeout)
+
+func newAdmission(limit, queue int, timeout time.Duration) *admission {
+	if limit <= 0 {
+		return nil
+	}
+	return &admission{
+		slots:    make(chan struct{}, limit),
+		maxQueue: int64(queue),
+		timeout:  timeout,
+	}
+}
+
+// acquire takes a slot, waiting in the queue for up to a.timeout when
+// none is free. Every successful acquire must be paired with release.
+func (a *admission) acquire(ctx context.Context) error {
+	select {
+	case a.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	if a.waiting.Add(1) > a.maxQueue {
+		a.waiting.Add(-1)
+		return errQueueFull
+	}
+	defer a.waiting.Add(-1)
+
+	timer := time.NewTimer(a.timeout)
+	defer timer.Stop()
+
+	select {
+	case a.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return errQueueTimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (a *admission) release() {
+	<-a.slots
+}
+
+// stats reports the configured limit, running and queued requests.
+func (a *admission) stats() *ConcurrencyStats {
+	if a == nil {
+		return nil
+	}
+	return &ConcurrencyStats{
+		Limit:  cap(a.slots),
+		Active: len(a.slots),
+		Queued: a.waiting.Load(),
+	}
+}
+
+// withAdmission holds a DB-bound handler until admit has a free slot,
+// shedding load with 503 when the queue is full or the wait times out.
+func withAdmission(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if admit == nil {
+			next(w, r)
+			return
+		}
+
+		if err := admit.acquire(r.Context()); err != nil {
+			if r.Context().Err() != nil {
+				return
+			}
+			queueRejections.WithLabelValues(rejectionReason(err)).Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(max(1, int(admit.timeout.Seconds()))))
+			respondJSON(w, r, http.StatusServiceUnavailable, ErrorResponse{
+				Error:     "Server busy",
+				Message:   err.Error(),
+				RequestID: requestID(r),
+			})
+			return
+		}
+		defer admit.release()
+
+		next(w, r)
+	}
+}
+
+func rejectionReason(err error) string {
+	if errors.Is(err, errQueueFull) {
+		return "queue_full"
+	}
+	return "timeout"
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ---- QUERY ARGS ----
+
+// bindStatement prepares a client statement for execution: list
+// placeholders are expanded, ? placeholders normalized for the driver and
+// args converted to driver values.
+func bindStatement(sql string, args []interface{}, argTypes []string) (string, []interface{}, error) {
+	sql, args, argTypes, err := expandListArgs(sql, args, argTypes)
+	if err != nil {
+		return "", nil, err
+	}
+	bound, err := bindArgs(args, argTypes)
+	if err != nil {
+		return "", nil, err
+	}
+	return normalizePlaceholders(sql), bound, nil
+}
+
+// expandListArgs turns each ?... placeholder, typically written as
+// IN (?...), into one ? per element of its array arg, splicing the
+// elements into args. The arg's type, if given, applies to every element.
+// On Postgres this relies on ? placeholders, i.e. NORMALIZE_PLACEHOLDERS.
+func expandListArgs(sql string, args []interface{}, argTypes []string) (string, []interface{}, []string, error) {
+	if !strings.Contains(sql, "?...") {
+		return sql, args, argTypes, nil
+	}
+	if len(argTypes) > 0 && len(argTypes) != len(args) {
+		return "", nil, nil, fmt.Errorf("argTypes has %d entries but args has %d", len(argTypes), len(args))
+	}
+
+	typeAt := func(i int) string {
+		if len(argTypes) == 0 {
+			return ""
+		}
+		return argTypes[i]
+	}
+
+	tokens := tokenize(sql)
+	var b strings.Builder
+	var outArgs []interface{}
+	var outTypes []string
+	n := 0
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		if t.kind != tokPlaceholder || t.text != "?" {
+			b.WriteString(t.text)
+			continue
+		}
+		if n >= len(args) {
+			return "", nil, nil, fmt.Errorf("statement has more placeholders than args")
+		}
+		typ := typeAt(n)
+
+		isList := i+3 < len(tokens) && tokens[i+1].text == "." && tokens[i+2].text == "." && tokens[i+3].text == "."
+		if !isList {
+			b.WriteString("?")
+			outArgs = append(outArgs, args[n])
+			outTypes = append(outTypes, typ)
+			n++
+			continue
+		}
+
+		list, ok := args[n].([]interface{})
+		if !ok {
+			return "", nil, nil, fmt.Errorf("args[%d]: ?... needs an array", n)
+		}
+		if len(list) == 0 {
+			return "", nil, nil, fmt.Errorf("args[%d]: ?... needs at least one element", n)
+		}
+		b.WriteString(strings.Repeat("?, ", len(list)-1) + "?")
+		for _, v := range list {
+			outArgs = append(outArgs, v)
+			outTypes = append(outTypes, typ)
+		}
+		n++
+		i += 3
+	}
+	for ; n < len(args); n++ {
+		outArgs = append(outArgs, args[n])
+		outTypes = append(outTypes, typeAt(n))
+	}
+
+	if len(argTypes) == 0 {
+		outTypes = nil
+	}
+	return b.String(), outArgs, outTypes, nil
+}
+
+// bindArgs converts JSON-decoded args into driver values. argTypes, when
+// given, must have one entry per arg and names the Go type each is coerced
+// to ("int", "float", "string", "bool", "time"; "" leaves an arg as is).
+// Untyped numbers bind as int64 when integral and float64 otherwise, so an
+// ID doesn't turn into 42.0.
+func bindArgs(args []interface{}, argTypes []string) ([]interface{}, error) {
+	if len(argTypes) > 0 && len(argTypes) != len(args) {
+		return nil, fmt.Errorf("argTypes has %d entries but args has %d", len(argTypes), len(args))
+	}
+
+	out := make([]interface{}, len(args))
+	for i, v := range args {
+		typ := ""
+		if len(argTypes) > 0 {
+			typ = argTypes[i]
+		}
+		bound, err := coerceArg(v, typ)
+		if err != nil {
+			return nil, fmt.Errorf("args[%d]: %w", i, err)
+		}
+		out[i] = bound
+	}
+	return out, nil
+}
+
+func coerceArg(v interface{}, typ string) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	switch v.(type) {
+	case []interface{}, map[string]interface{}:
+		return nil, fmt.Errorf("arrays and objects cannot be bound")
+	}
+
+	switch typ {
+	case "":
+		if n, ok := v.(json.Number); ok {
+			if i, err := n.Int64(); err == nil {
+				return i, nil
+			}
+			return n.Float64()
+		}
+		return v, nil
+
+	case "int":
+		switch val := v.(type) {
+		case json.Number:
+			if i, err := val.Int64(); err == nil {
+				return i, nil
+			}
+		case string:
+			if i, err := strconv.ParseInt(val, 10, 64); err == nil {
+				return i, nil
+			}
+		}
+
+	case "float":
+		switch val := v.(type) {
+		case json.Number:
+			if f, err := val.Float64(); err == nil {
+				return f, nil
+			}
+		case string:
+			if f, err := strconv.ParseFloat(val, 64); err == nil {
+				return f, nil
+			}
+		}
+
+	case "string":
+		switch val := v.(type) {
+		case string:
+			return val, nil
+		case json.Number:
+			return val.String(), nil
+		case bool:
+			return strconv.FormatBool(val), nil
+		}
+
+	case "bool":
+		switch val := v.(type) {
+		case bool:
+			return val, nil
+		case string:
+			if b, err := strconv.ParseBool(val); err == nil {
+				return b, nil
+			}
+		}
+
+	case "time":
+		if s, ok := v.(string); ok {
+			if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+				return t, nil
+			}
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown arg type %q", typ)
+	}
+
+	return nil, fmt.Errorf("cannot convert %v to %s", v, typ)
+}
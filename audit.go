@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// ---- AUDIT & QUERY METRICS ----
+
+// statusClientClosed is nginx's non-standard "client closed request".
+const statusClientClosed = 499
+
+// auditEntry is one line of AUDIT_LOG. Fingerprint and Query group
+// entries by statement shape; SQL keeps the statement as received, less
+// whatever LOG_SQL_* trims. Args is only filled with AUDIT_ARGS.
+type auditEntry struct {
+	Time        time.Time     `json:"time"`
+	RequestID   string        `json:"requestId"`
+	ClientIP    string        `json:"clientIp"`
+	Scope       string        `json:"scope"`
+	Label       string        `json:"label,omitempty"`
+	Type        string        `json:"type"`
+	Fingerprint string        `json:"fingerprint"`
+	Query       string        `json:"query"`
+	SQL         string        `json:"sql"`
+	Status      int           `json:"status"`
+	DurationMs  int64         `json:"durationMs"`
+	Error       string        `json:"error,omitempty"`
+	Args        []interface{} `json:"args,omitempty"`
+}
+
+// auditLog appends JSON lines to AUDIT_LOG; it is nil when unset.
+type auditLog struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+var audit = openAuditLog(auditLogPath)
+
+func openAuditLog(path string) *auditLog {
+	if path == "" {
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		log.Fatalf("AUDIT_LOG: %v", err)
+	}
+	return &auditLog{enc: json.NewEncoder(f)}
+}
+
+func (a *auditLog) record(e auditEntry) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.enc.Encode(e); err != nil {
+		log.Printf("[%s] audit write failed: %v", e.RequestID, err)
+	}
+}
+
+// auditedArgs returns a statement's args for its audit entry, masking
+// those bound to sensitive columns and at positions AUDIT_REDACT_ARGS
+// lists for any statement or for its label. Long values are logged by
+// size, as in body logs.
+func auditedArgs(info *requestInfo) []interface{} {
+	if len(info.args) == 0 {
+		return nil
+	}
+	sensitive := sensitiveArgs(info.sql)
+	out := make([]interface{}, len(info.args))
+	for i, arg := range info.args {
+		if sensitive[i] || auditRedactArgs[""][i+1] || (info.label != "" && auditRedactArgs[info.label][i+1]) {
+			out[i] = redactedMarker
+			continue
+		}
+		switch val := arg.(type) {
+		case string:
+			out[i] = redactLogged(val)
+		case []byte:
+			out[i] = fmt.Sprintf("…(%d bytes)", len(val))
+		default:
+			out[i] = val
+		}
+	}
+	return out
+}
+
+// loggedSQL is the form of a statement written to logs and traces, with
+// comments removed and the length capped as LOG_SQL_* configure. Comments
+// are where clients tend to embed literal payloads and trace context.
+func loggedSQL(sql string) string {
+	if logSQLStripComments {
+		var b strings.Builder
+		for _, t := range tokenize(sql) {
+			if t.kind != tokComment {
+				b.WriteString(t.text)
+			}
+		}
+		sql = strings.TrimSpace(b.String())
+	}
+	if logSQLMaxLength > 0 && utf8.RuneCountInString(sql) > logSQLMaxLength {
+		sql = string([]rune(sql)[:logSQLMaxLength]) + "…"
+	}
+	return sql
+}
+
+// observeQuery records metrics and an audit entry for every statement
+// the wrapped handler got far enough to parse. Labels use the query
+// fingerprint rather than the SQL, and are capped like client labels
+// (see metricFingerprint and metricType), to keep their cardinality
+// bounded.
+func observeQuery(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+
+		info := requestInfoFrom(r)
+		if info.sql == "" {
+			return
+		}
+		elapsed := time.Since(start)
+		fingerprint := queryFingerprint(info.sql)
+
+		// A client that disconnected isn't a failure of ours; it is audited
+		// as 499 but left out of the metrics.
+		if errors.Is(r.Context().Err(), context.Canceled) {
+			rec.status = statusClientClosed
+		} else {
+			typ, fp, label := metricType(info.queryType), metricFingerprint(fingerprint), metricLabel(info.label)
+			queriesTotal.WithLabelValues(typ, fp, label, strconv.Itoa(rec.status)).Inc()
+			queryDuration.WithLabelValues(typ, fp, label).Observe(elapsed.Seconds())
+		}
+
+		entry := auditEntry{
+			Time:        start.UTC(),
+			RequestID:   info.id,
+			ClientIP:    info.clientIP,
+			Scope:       callerScope(r),
+			Label:       info.label,
+			Type:        info.queryType,
+			Fingerprint: fingerprint,
+			Query:       normalizeSQL(info.sql),
+			SQL:         loggedSQL(info.sql),
+			Status:      rec.status,
+			DurationMs:  elapsed.Milliseconds(),
+		}
+		if info.err != nil {
+			entry.Error = info.err.Error()
+		}
+		if auditArgs {
+			entry.Args = auditedArgs(info)
+		}
+		audit.record(entry)
+
+		if slowQueryThreshold > 0 && elapsed >= slowQueryThreshold {
+			logSlowQuery(info, fingerprint, elapsed, rec.status)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// ---- AUTH ----
+
+const (
+	apiKeyCtxKey ctxKey = iota + 100
+	scopeCtxKey
+)
+
+// Scopes, from least to most privileged: read runs row-returning
+// statements, write adds INSERT/UPDATE/DELETE, admin adds DDL and the
+// admin endpoints.
+const (
+	scopeRead  = "read"
+	scopeWrite = "write"
+	scopeAdmin = "admin"
+)
+
+var scopeRank = map[string]int{scopeRead: 1, scopeWrite: 2, scopeAdmin: 3}
+
+// keyScopes maps every accepted key to its scope; keyTables holds the
+// table allowlists of keys restricted to certain tables.
+var keyScopes, keyTables = loadKeys()
+
+func loadKeys() (map[string]string, map[string]map[string]bool) {
+	scopes := map[string]string{}
+	tables := map[string]map[string]bool{}
+	for _, k := range apiKeys {
+		scopes[k] = scopeAdmin
+	}
+	if apiKeysFile == "" {
+		return scopes, tables
+	}
+
+	data, err := os.ReadFile(apiKeysFile)
+	if err != nil {
+		log.Fatalf("API_KEYS_FILE: %v", err)
+	}
+	var entries []struct {
+		Key    string   `json:"key"`
+		Scope  string   `json:"scope"`
+		Tables []string `json:"tables"`
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		log.Fatalf("API_KEYS_FILE: %v", err)
+	}
+	for i, e := range entries {
+		if e.Key == "" {
+			log.Fatalf("API_KEYS_FILE: entry %d has no key", i)
+		}
+		if _, ok := scopeRank[e.Scope]; !ok {
+			log.Fatalf("API_KEYS_FILE: entry %d has scope %q, want read, write or admin", i, e.Scope)
+		}
+		scopes[e.Key] = e.Scope
+		if len(e.Tables) > 0 {
+			allowed := map[string]bool{}
+			for _, t := range e.Tables {
+				allowed[strings.ToLower(t)] = true
+			}
+			tables[e.Key] = allowed
+		}
+	}
+	return scopes, tables
+}
+
+// requireAPIKey rejects requests that don't present one of the configured
+// keys and records the caller's scope. With no keys configured the
+// service stays open and every caller is admin.
+func requireAPIKey(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(keyScopes) == 0 {
+			next(w, r)
+			return
+		}
+
+		key := presentedKey(r)
+		scope, ok := lookupKey(key)
+		if !ok {
+			respondJSON(w, r, http.StatusUnauthorized, ErrorResponse{
+				Error:     "Invalid or missing API key",
+				RequestID: requestID(r),
+			})
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), apiKeyCtxKey, key)
+		ctx = context.WithValue(ctx, scopeCtxKey, scope)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+func presentedKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+// lookupKey finds the scope of key, comparing against every configured key
+// in constant time.
+func lookupKey(key string) (string, bool) {
+	if key == "" {
+		return "", false
+	}
+	scope, found := "", false
+	for k, sc := range keyScopes {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(k)) == 1 {
+			scope, found = sc, true
+		}
+	}
+	return scope, found
+}
+
+// apiKey returns the authenticated caller's key, or "" when auth is off.
+func apiKey(r *http.Request) string {
+	key, _ := r.Context().Value(apiKeyCtxKey).(string)
+	return key
+}
+
+// callerScope returns the authenticated caller's scope; admin when auth
+// is off.
+func callerScope(r *http.Request) string {
+	if scope, ok := r.Context().Value(scopeCtxKey).(string); ok {
+		return scope
+	}
+	return scopeAdmin
+}
+
+// scopeAllows reports whether scope may run a statement of queryType.
+func scopeAllows(scope, queryType string) bool {
+	switch {
+	case isReadQuery(queryType):
+		return scopeRank[scope] >= scopeRank[scopeRead]
+	case queryType == "INSERT" || queryType == "UPDATE" || queryType == "DELETE":
+		return scopeRank[scope] >= scopeRank[scopeWrite]
+	default:
+		return scope == scopeAdmin
+	}
+}
+
+// tableRestriction says what in sqlQuery the caller's key may not use,
+// e.g. "table secret", or returns "" if all of it is allowed. Keys without
+// a "tables" list are unrestricted. An allowlist entry "orders" matches
+// orders in any schema; "shop.orders" only that one. Statements whose
+// tables referencedTables can't find (CALL, COPY, PREPARE, ...) are
+// refused outright, since they could name any table.
+func tableRestriction(r *http.Request, sqlQuery string) string {
+	if _, ok := keyTables[apiKey(r)]; !ok {
+		return ""
+	}
+	if !statementShapeKnown(sqlQuery) {
+		return statementType(sqlQuery) + " statement"
+	}
+	for _, name := range referencedTables(sqlQuery) {
+		if !tableAllowed(r, name) {
+			return "table " + name
+		}
+	}
+	return ""
+}
+
+// tableAllowed checks one lower-cased "name" or "schema.name" against the
+// caller's allowlist.
+func tableAllowed(r *http.Request, name string) bool {
+	allowed, ok := keyTables[apiKey(r)]
+	if !ok {
+		return true
+	}
+	bare := name
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		bare = name[i+1:]
+	}
+	return allowed[name] || allowed[bare]
+}
+
+// rowLimitFor returns the SELECT row cap for a caller: its MAX_ROWS_PER_KEY
+// override if any, otherwise MAX_ROWS. 0 means unlimited.
+func rowLimitFor(key string) int {
+	if limit, ok := maxRowsPerKey[key]; ok && key != "" {
+		return limit
+	}
+	return maxRows
+}
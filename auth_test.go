@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTableRestriction(t *testing.T) {
+	saved := keyTables
+	defer func() { keyTables = saved }()
+	keyTables = map[string]map[string]bool{"limited": {"a": true}}
+
+	r := httptest.NewRequest("POST", "/query", nil)
+	limited := r.WithContext(context.WithValue(r.Context(), apiKeyCtxKey, "limited"))
+
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"SELECT * FROM a", ""},
+		{"SELECT * FROM shop.a", ""},
+		{"SELECT 1", ""},
+		{"SELECT * FROM secret", "table secret"},
+		{"SELECT * FROM (secret)", "table secret"},
+		{"SELECT * FROM a JOIN (secret) ON 1", "table secret"},
+		{"WITH secret AS (SELECT * FROM secret) SELECT * FROM secret", "table secret"},
+		{"WITH x AS (SELECT * FROM a) SELECT * FROM x", ""},
+		{"TRUNCATE secret", "table secret"},
+		{"DESCRIBE secret", "table secret"},
+		{"CALL read_secret()", "CALL statement"},
+		{"COPY secret TO STDOUT", "COPY statement"},
+	}
+	for _, tt := range tests {
+		if got := tableRestriction(limited, tt.sql); got != tt.want {
+			t.Errorf("tableRestriction(%q) = %q, want %q", tt.sql, got, tt.want)
+		}
+	}
+
+	if got := tableRestriction(r, "CALL read_secret()"); got != "" {
+		t.Errorf("unrestricted key: tableRestriction = %q, want \"\"", got)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ---- REQUEST BODY ----
+
+var (
+	errBodyTooLarge  = errors.New("request body too large")
+	errMalformedGzip = errors.New("malformed gzip body")
+)
+
+// bodyError is a client error from reading a request body.
+type bodyError struct {
+	status int
+	msg    string
+}
+
+// decodeJSONBody decodes r's body into v, transparently inflating
+// Content-Encoding: gzip. MAX_BODY_BYTES bounds both the bytes on the wire
+// and the decompressed size, so a small zip bomb can't expand unchecked.
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v interface{}) *bodyError {
+	var body io.Reader = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+
+	if strings.EqualFold(r.Header.Get("Content-Encoding"), "gzip") {
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			return classifyBodyError(fmt.Errorf("%w: %v", errMalformedGzip, err))
+		}
+		defer zr.Close()
+		body = &boundedReader{r: &gzipReader{zr}, remaining: maxBodyBytes}
+	}
+
+	// UseNumber keeps numeric args exact until bindArgs types them.
+	dec := json.NewDecoder(body)
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return classifyBodyError(err)
+	}
+	return nil
+}
+
+func classifyBodyError(err error) *bodyError {
+	var maxErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxErr), errors.Is(err, errBodyTooLarge):
+		return &bodyError{http.StatusRequestEntityTooLarge, "Request body too large"}
+	case errors.Is(err, errMalformedGzip):
+		return &bodyError{http.StatusBadRequest, "Malformed gzip body"}
+	default:
+		return &bodyError{http.StatusBadRequest, "Invalid JSON body"}
+	}
+}
+
+// gzipReader tags decompression failures so they can be told apart from
+// JSON syntax errors.
+type gzipReader struct {
+	zr *gzip.Reader
+}
+
+func (g *gzipReader) Read(p []byte) (int, error) {
+	n, err := g.zr.Read(p)
+	if err != nil && err != io.EOF {
+		var maxErr *http.MaxBytesError
+		if !errors.As(err, &maxErr) {
+			err = fmt.Errorf("%w: %v", errMalformedGzip, err)
+		}
+	}
+	return n, err
+}
+
+// boundedReader fails with errBodyTooLarge once more than remaining bytes
+// have been read.
+type boundedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (b *boundedReader) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, errBodyTooLarge
+	}
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.r.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return n, errBodyTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ---- BODY LOGGING ----
+
+// sensitiveName matches column and field names whose values are never
+// logged.
+var sensitiveName = regexp.MustCompile(`(?i)passw|pwd|token|secret|api_?key|credential`)
+
+// logStringMax caps each string inside a logged JSON body, so encoded
+// binary cells show up as their size rather than their content.
+const logStringMax = 256
+
+// withBodyLogging logs each request and response body with the request ID
+// when LOG_BODIES is set, for diagnosing client integrations. Bodies are
+// captured up to LOG_BODY_MAX_BYTES. In JSON bodies, fields with sensitive
+// names, args bound to sensitive columns, long strings and the caller's
+// API key are masked; bodies that aren't complete JSON (cut at the cap,
+// form or plain text, binary) are only logged by size, since nothing in
+// them could be masked reliably.
+func withBodyLogging(next http.Handler) http.Handler {
+	if !logBodies {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqBody := &cappedBuffer{max: logBodyMaxBytes}
+		if r.Body != nil {
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.TeeReader(r.Body, reqBody), r.Body}
+		}
+		rec := &bodyRecorder{ResponseWriter: w, status: http.StatusOK, body: cappedBuffer{max: logBodyMaxBytes}}
+
+		next.ServeHTTP(rec, r)
+
+		key := presentedKey(r)
+		log.Printf("[%s] request %s %s: %s", requestID(r), r.Method, r.URL.Path,
+			loggedBody(reqBody, r.Header.Get("Content-Type"), r.Header.Get("Content-Encoding"), key))
+		log.Printf("[%s] response %d: %s", requestID(r), rec.status,
+			loggedBody(&rec.body, rec.Header().Get("Content-Type"), rec.Header().Get("Content-Encoding"), key))
+	})
+}
+
+// cappedBuffer keeps the first max bytes written to it and counts the
+// rest.
+type cappedBuffer struct {
+	bytes.Buffer
+	max   int
+	total int64
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	b.total += int64(len(p))
+	if room := b.max - b.Len(); room > 0 {
+		b.Buffer.Write(p[:min(room, len(p))])
+	}
+	return len(p), nil
+}
+
+// bodyRecorder passes a response through while keeping the start of it.
+type bodyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   cappedBuffer
+}
+
+func (rec *bodyRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *bodyRecorder) Write(p []byte) (int, error) {
+	rec.body.Write(p)
+	return rec.ResponseWriter.Write(p)
+}
+
+func (rec *bodyRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (rec *bodyRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// loggedBody renders a captured body for the log.
+func loggedBody(b *cappedBuffer, contentType, encoding, key string) string {
+	if b.total == 0 {
+		return "(empty)"
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	textual := mediaType == "" || strings.HasPrefix(mediaType, "text/") ||
+		strings.Contains(mediaType, "json") || strings.Contains(mediaType, "javascript")
+	if !textual || encoding != "" || !isText(b.Bytes()) {
+		kind := mediaType
+		if encoding != "" {
+			kind += ", " + encoding
+		}
+		return fmt.Sprintf("[%d bytes %s]", b.total, strings.TrimPrefix(kind, ", "))
+	}
+
+	// A body that was cut or isn't JSON can't be told apart into fields,
+	// so nothing in it could be masked: only its size is logged.
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(b.Bytes()))
+	dec.UseNumber()
+	if int64(b.Len()) < b.total || dec.Decode(&v) != nil || dec.More() {
+		return fmt.Sprintf("[%d bytes %s]", b.total, cmp.Or(mediaType, "unparsed"))
+	}
+	redacted, _ := json.Marshal(redactLogged(v))
+	out := string(redacted)
+	if key != "" {
+		out = strings.ReplaceAll(out, key, redactedMarker)
+	}
+	return out
+}
+
+// isText reports whether b is UTF-8, allowing for a rune split by the
+// size cap at the end.
+func isText(b []byte) bool {
+	for i := 0; i < utf8.UTFMax-1 && len(b) > 0 && !utf8.Valid(b); i++ {
+		b = b[:len(b)-1]
+	}
+	return utf8.Valid(b)
+}
+
+// redactLogged masks sensitive values in a decoded JSON body: fields with
+// sensitive names, args bound to sensitive columns of a statement's
+// "sql", and the content of long strings.
+func redactLogged(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		sqlText, _ := val["sql"].(string)
+		for k, field := range val {
+			if sensitiveName.MatchString(k) {
+				val[k] = redactedMarker
+				continue
+			}
+			val[k] = redactLogged(field)
+		}
+		if args, ok := val["args"].([]interface{}); ok && sqlText != "" {
+			for i := range sensitiveArgs(sqlText) {
+				if i < len(args) {
+					args[i] = redactedMarker
+				}
+			}
+		}
+		return val
+	case []interface{}:
+		for i := range val {
+			val[i] = redactLogged(val[i])
+		}
+		return val
+	case string:
+		if len(val) > logStringMax {
+			return fmt.Sprintf("…(%d bytes)", len(val))
+		}
+		return val
+	}
+	return v
+}
+
+// sensitiveArgs finds the placeholders in sql bound to columns with
+// sensitive names or listed in REDACT_COLUMNS: by position in an INSERT's
+// VALUES against its column list, or as the right side of "column =". It
+// returns their arg indexes.
+func sensitiveArgs(sql string) map[int]bool {
+	sig := significant(tokenize(sql))
+	out := map[int]bool{}
+
+	var insertCols []string
+	if len(sig) > 0 && (sig[0].is("INSERT") || sig[0].is("REPLACE")) {
+		for i := 0; i < len(sig) && !sig[i].is("VALUES") && !sig[i].is("SELECT"); i++ {
+			if sig[i].text != "(" {
+				continue
+			}
+			for i++; i < len(sig) && sig[i].text != ")"; i++ {
+				if isIdent(sig[i]) {
+					insertCols = append(insertCols, unquoteIdent(sig[i]))
+				}
+			}
+			break
+		}
+	}
+
+	seq, depth, col := 0, 0, 0
+	inValues := false
+	for i, t := range sig {
+		switch {
+		case t.is("VALUES"):
+			inValues = true
+		case t.text == "(":
+			depth++
+			if depth == 1 {
+				col = 0
+			}
+		case t.text == ")":
+			depth--
+		case t.text == "," && depth == 1:
+			col++
+		}
+		if t.kind != tokPlaceholder {
+			continue
+		}
+
+		idx := seq
+		if t.text == "?" {
+			seq++
+		} else {
+			n, _ := strconv.Atoi(t.text[1:])
+			idx = n - 1
+		}
+		name := ""
+		if inValues && depth == 1 && col < len(insertCols) {
+			name = insertCols[col]
+		} else if i >= 2 && sig[i-1].text == "=" && isIdent(sig[i-2]) {
+			name = unquoteIdent(sig[i-2])
+		}
+		if name != "" && (sensitiveName.MatchString(name) || isRedacted(name)) {
+			out[idx] = true
+		}
+	}
+	return out
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/sony/gobreaker/v2"
+)
+
+// ---- CIRCUIT BREAKER ----
+
+// dbBreaker fast-fails DB-bound requests after BREAKER_FAILURES consecutive
+// connectivity failures, probing again once BREAKER_COOLDOWN has passed.
+// It is nil when BREAKER_FAILURES=0.
+var dbBreaker = newDBBreaker()
+
+func newDBBreaker() *gobreaker.TwoStepCircuitBreaker[any] {
+	if breakerFailures <= 0 {
+		return nil
+	}
+	return gobreaker.NewTwoStepCircuitBreaker[any](gobreaker.Settings{
+		Name:        "db",
+		MaxRequests: 1,
+		Timeout:     breakerCooldown,
+		ReadyToTrip: func(c gobreaker.Counts) bool {
+			return c.ConsecutiveFailures >= uint32(breakerFailures)
+		},
+		IsSuccessful: func(err error) bool {
+			return !isDBUnavailable(err)
+		},
+	})
+}
+
+// isDBUnavailable separates "the database could not be reached" from
+// errors the server itself returned (bad SQL, constraint violations),
+// results this runner refused (MAX_COLUMNS, MAX_RESPONSE_BYTES) and
+// statements that ran into its own deadlines (QUERY_TIMEOUT, TX_TIMEOUT),
+// none of which say anything about its health. A slow query is the
+// client's problem, not a reason to turn everyone away.
+func isDBUnavailable(err error) bool {
+	var limit *resultLimitError
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.As(err, &limit) {
+		return false
+	}
+	var myErr *mysql.MySQLError
+	var pgErr *pgconn.PgError
+	return !errors.As(err, &myErr) && !errors.As(err, &pgErr)
+}
+
+// withBreaker guards a DB-bound handler with dbBreaker. The outcome is
+// taken from the error respondErr recorded for the request.
+func withBreaker(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if dbBreaker == nil {
+			next(w, r)
+			return
+		}
+
+		done, err := dbBreaker.Allow()
+		if err != nil {
+			w.Header().Set("Retry-After", strconv.Itoa(int(breakerCooldown.Seconds())))
+			respondJSON(w, r, http.StatusServiceUnavailable, ErrorResponse{
+				Error:     "Database unavailable",
+				Message:   "circuit breaker is " + dbBreaker.State().String(),
+				RequestID: requestID(r),
+			})
+			return
+		}
+
+		next(w, r)
+		done(requestInfoFrom(r).err)
+	}
+}
+
+func breakerState() string {
+	if dbBreaker == nil {
+		return "disabled"
+	}
+	return dbBreaker.State().String()
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestIsDBUnavailable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"client went away", context.Canceled, false},
+		{"QUERY_TIMEOUT", context.DeadlineExceeded, false},
+		{"wrapped deadline", fmt.Errorf("statement 2: %w", context.DeadlineExceeded), false},
+		{"mysql error", &mysql.MySQLError{Number: 1064}, false},
+		{"postgres error", &pgconn.PgError{Code: "42601"}, false},
+		{"wrapped server error", fmt.Errorf("statement 1: %w", &mysql.MySQLError{Number: 1146}), false},
+		{"result limit", &resultLimitError{"Too many columns", "result has 9 columns, limit is 8"}, false},
+		{"wrapped result limit", fmt.Errorf("statement 0: %w", &resultLimitError{"Response too large", "too big"}), false},
+		{"bad connection", driver.ErrBadConn, true},
+		{"dial failure", errors.New("dial tcp 127.0.0.1:3306: connect: connection refused"), true},
+	}
+	for _, tt := range tests {
+		if got := isDBUnavailable(tt.err); got != tt.want {
+			t.Errorf("%s: isDBUnavailable(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
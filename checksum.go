@@ -0,0 +1,24 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// ---- CHECKSUM ----
+
+// resultChecksum hashes rows in order, each canonicalised as a JSON object
+// with sorted keys on its own line, so equal results from two deployments
+// hash the same. Values go through the same conversion as the response,
+// so deployments should agree on OUTPUT_TIMEZONE and column transforms.
+func resultChecksum(rows []map[string]interface{}) (string, error) {
+	h := sha256.New()
+	enc := json.NewEncoder(h)
+	for _, row := range rows {
+		if err := enc.Encode(row); err != nil {
+			return "", err
+		}
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+)
+
+// ---- ONE-SHOT CLI ----
+
+// cliFormats maps each -format value to the endpoint that produces it and
+// how it is asked for there.
+var cliFormats = map[string]struct {
+	path, accept string
+}{
+	"json":    {"/query", ""},
+	"ndjson":  {"/query?format=ndjson", ""},
+	"html":    {"/query?format=html", ""},
+	"parquet": {"/query?format=parquet", ""},
+	"msgpack": {"/query", "application/msgpack"},
+	"csv":     {"/export", ""},
+}
+
+// runCLI runs one statement through the same handlers the server uses,
+// writing the result to stdout, and returns the process exit code: 0 on
+// success, 1 when the statement failed (its error body goes to stderr)
+// and 2 for bad usage. sqlText "-" reads the statement from stdin.
+// Results that fail part way through a stream are only logged, as for
+// HTTP clients.
+func runCLI(sqlText, format string) int {
+	target, ok := cliFormats[format]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown -format %q: use json, ndjson, csv, html, parquet or msgpack\n", format)
+		return 2
+	}
+	if sqlText == "-" {
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "reading stdin:", err)
+			return 2
+		}
+		sqlText = string(b)
+	}
+
+	var payload interface{} = QueryRequest{SQL: sqlText}
+	handler := observeQuery(withBreaker(queryHandler))
+	if format == "csv" {
+		payload = ExportRequest{SQL: sqlText}
+		handler = withBreaker(exportHandler)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	req, err := http.NewRequest(http.MethodPost, target.path, bytes.NewReader(body))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if target.accept != "" {
+		req.Header.Set("Accept", target.accept)
+	}
+
+	w := &cliResponseWriter{header: http.Header{}, out: bufio.NewWriter(os.Stdout)}
+	withRequestID(handler).ServeHTTP(w, req)
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if err := w.out.Flush(); err != nil {
+		fmt.Fprintln(os.Stderr, "writing result:", err)
+		return 1
+	}
+	if w.status >= 400 {
+		return 1
+	}
+	return 0
+}
+
+// cliResponseWriter sends a successful response body to stdout and an
+// error body to stderr. Headers are dropped.
+type cliResponseWriter struct {
+	header http.Header
+	status int
+	out    *bufio.Writer
+}
+
+func (w *cliResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *cliResponseWriter) WriteHeader(status int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = status
+	if status >= 400 {
+		w.out = bufio.NewWriter(os.Stderr)
+	}
+}
+
+func (w *cliResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.out.Write(p)
+}
+
+func (w *cliResponseWriter) Flush() {
+	w.out.Flush()
+}
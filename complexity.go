@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// ---- QUERY COMPLEXITY ----
+
+// queryComplexity is what MAX_JOINS, MAX_SUBQUERY_DEPTH and MAX_UNIONS
+// limit.
+type queryComplexity struct {
+	joins, subqueryDepth, unions int
+}
+
+// measureComplexity counts a statement's JOINs and UNIONs and how deeply
+// its subqueries nest, from its tokens, so comments and literals don't
+// count. A parenthesised SELECT or WITH is a subquery, CTE bodies
+// included.
+func measureComplexity(sql string) queryComplexity {
+	var c queryComplexity
+	sig := significant(tokenize(sql))
+	var parens []bool // per open paren, whether it opened a subquery
+	depth := 0
+	for i, t := range sig {
+		switch {
+		case t.text == "(":
+			sub := i+1 < len(sig) && (sig[i+1].is("SELECT") || sig[i+1].is("WITH"))
+			parens = append(parens, sub)
+			if sub {
+				depth++
+				c.subqueryDepth = max(c.subqueryDepth, depth)
+			}
+		case t.text == ")":
+			if n := len(parens); n > 0 {
+				if parens[n-1] {
+					depth--
+				}
+				parens = parens[:n-1]
+			}
+		case t.is("JOIN"), t.is("STRAIGHT_JOIN"):
+			c.joins++
+		case t.is("UNION"):
+			c.unions++
+		}
+	}
+	return c
+}
+
+// checkComplexity answers 400 and returns false when sqlQuery exceeds any
+// of the complexity limits. index, when set, is echoed in the error body.
+func checkComplexity(w http.ResponseWriter, r *http.Request, sqlQuery string, index *int) bool {
+	if maxJoins <= 0 && maxSubqueryDepth <= 0 && maxUnions <= 0 {
+		return true
+	}
+
+	c := measureComplexity(sqlQuery)
+	var msg string
+	switch {
+	case maxJoins > 0 && c.joins > maxJoins:
+		msg = fmt.Sprintf("query has %d joins, limit is %d", c.joins, maxJoins)
+	case maxSubqueryDepth > 0 && c.subqueryDepth > maxSubqueryDepth:
+		msg = fmt.Sprintf("subqueries nest %d deep, limit is %d", c.subqueryDepth, maxSubqueryDepth)
+	case maxUnions > 0 && c.unions > maxUnions:
+		msg = fmt.Sprintf("query has %d unions, limit is %d", c.unions, maxUnions)
+	default:
+		return true
+	}
+	respondJSON(w, r, http.StatusBadRequest, ErrorResponse{
+		Error:     "Query too complex",
+		Message:   msg,
+		Statement: index,
+	})
+	return false
+}
@@ -0,0 +1,520 @@
+package main
+
+import (
+	"log"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ---- CONFIG ----
+
+const addr = ":3000"
+
+const (
+	driverMySQL    = "mysql"
+	driverPostgres = "postgres"
+)
+
+const (
+	errorFormatDefault = "default"
+	errorFormatProblem = "problem"
+)
+
+const (
+	zeroDateNull     = "null"
+	zeroDateString   = "string"
+	zeroDateSentinel = "sentinel"
+)
+
+var (
+	// dbDriver selects the backend: mysql (default) or postgres.
+	dbDriver = envEnum("DB_DRIVER", driverMySQL, driverMySQL, driverPostgres)
+
+	dsn = envString("DB_DSN", "root:password@tcp(localhost:3306)/test_db")
+
+	// normalizePlaceholdersOn lets clients always write ? placeholders; on
+	// Postgres they are rewritten to $n before execution.
+	normalizePlaceholdersOn = envBool("NORMALIZE_PLACEHOLDERS", false)
+
+	// readDSN points at a read replica for SELECT/SHOW/EXPLAIN traffic.
+	readDSN = envString("DB_READ_DSN", "")
+
+	// replicaLagCacheTTL is how long a measured replica lag is reused for
+	// maxReplicaLag routing and /health.
+	replicaLagCacheTTL = envDuration("REPLICA_LAG_CACHE", time.Second)
+
+	// hideErrorDetails keeps raw DB error strings out of client responses.
+	// The full error is still logged alongside the request ID.
+	hideErrorDetails = envBool("HIDE_ERROR_DETAILS", false)
+
+	// maxColumns caps the width of a SELECT result. 0 disables the check.
+	maxColumns = envInt("MAX_COLUMNS", 0)
+
+	// apiKeys lists the keys accepted on X-API-Key / Authorization: Bearer.
+	// They have admin scope; apiKeysFile adds scoped keys from a JSON file
+	// of [{"key": "...", "scope": "read|write|admin", "tables": [...]}],
+	// where the optional tables list restricts the key to those tables.
+	// With neither set, authentication is disabled.
+	apiKeys     = envList("API_KEYS")
+	apiKeysFile = envString("API_KEYS_FILE", "")
+
+	// maxRows caps SELECT results; maxRowsPerKey overrides it per API key
+	// ("key:limit,key:limit"). 0 means unlimited.
+	maxRows       = envInt("MAX_ROWS", 0)
+	maxRowsPerKey = envIntMap("MAX_ROWS_PER_KEY")
+
+	// maxBodyBytes bounds request bodies, measured after decompression.
+	maxBodyBytes = int64(envInt("MAX_BODY_BYTES", 10<<20))
+
+	// redactedColumns holds REDACT_COLUMNS entries ("column" or
+	// "table.column") whose values are replaced with "***" in results.
+	// Neither driver reports which table column a result column came from,
+	// so this matches result column names only: SELECT password AS p, or
+	// any expression over password, returns the value. It keeps secrets
+	// out of casual results and logs; it is not access control, which
+	// needs a key table allowlist or database grants.
+	redactedColumns = columnSet(envList("REDACT_COLUMNS"))
+
+	// columnTransformsFile names a JSON object mapping "table.column" to a
+	// transformer spec such as {"transform": "mask", "keep": 2}.
+	columnTransformsFile = envString("COLUMN_TRANSFORMS_FILE", "")
+
+	// namedQueriesFile names a JSON object mapping query names to saved
+	// statements, run with POST /queries/{name}.
+	namedQueriesFile = envString("NAMED_QUERIES_FILE", "")
+
+	// HTTP server timeouts. readHeaderTimeout and readTimeout stop slow
+	// clients holding connections while sending a request, idleTimeout
+	// closes unused keep-alive connections. writeTimeout bounds the whole
+	// response, streamed results included, so it is off by default; SSE
+	// subscriptions are exempt from it.
+	readHeaderTimeout = envDuration("READ_HEADER_TIMEOUT", 10*time.Second)
+	readTimeout       = envDuration("READ_TIMEOUT", time.Minute)
+	writeTimeout      = envDuration("WRITE_TIMEOUT", 0)
+	idleTimeout       = envDuration("IDLE_TIMEOUT", 2*time.Minute)
+
+	// shutdownTimeout is how long in-flight requests may drain on SIGTERM
+	// before their connections are forcibly closed.
+	shutdownTimeout = envDuration("SHUTDOWN_TIMEOUT", 30*time.Second)
+
+	// Idempotency-Key responses are kept for idempotencyTTL, up to
+	// idempotencyMaxKeys entries across all API keys.
+	idempotencyTTL     = envDuration("IDEMPOTENCY_TTL", 24*time.Hour)
+	idempotencyMaxKeys = envInt("IDEMPOTENCY_MAX_KEYS", 10000)
+
+	// breakerFailures consecutive DB connectivity failures open the circuit
+	// breaker for breakerCooldown. 0 disables the breaker.
+	breakerFailures = envInt("BREAKER_FAILURES", 5)
+	breakerCooldown = envDuration("BREAKER_COOLDOWN", 30*time.Second)
+
+	// costMaxRows is the planner's estimated examined-rows ceiling for a
+	// SELECT; costPolicy decides whether exceeding it rejects the query or
+	// only adds a warning to the response. 0 disables the check.
+	costMaxRows = float64(envInt("COST_MAX_ROWS", 0))
+	costPolicy  = envEnum("COST_POLICY", costReject, costReject, costWarn)
+
+	// tagQueries prefixes executed statements with /* req=<id> */ so DBAs
+	// can match DB-side logs to request IDs.
+	tagQueries = envBool("TAG_QUERIES", false)
+
+	// csvNull is how /export renders NULL, e.g. \N for LOAD DATA or NULL.
+	// The default empty string matches an empty value.
+	csvNull = os.Getenv("CSV_NULL")
+
+	// landingPage serves an HTML endpoint listing at / to browsers.
+	landingPage = envBool("LANDING_PAGE", true)
+
+	// requireWhere rejects UPDATE/DELETE without a WHERE clause unless the
+	// request sets confirmFullTable, like MySQL's --safe-updates.
+	requireWhere = envBool("REQUIRE_WHERE", false)
+
+	// etagEnabled adds an ETag to SELECT responses and answers a matching
+	// If-None-Match with 304. The query still runs to compute the hash.
+	etagEnabled = envBool("ETAG_ENABLED", false)
+
+	// DDL (anything that isn't a read or INSERT/UPDATE/DELETE) has its own
+	// policy: ddlRateLimit statements per minute per caller (0 = unlimited)
+	// and, with ddlRequireConfirm, an X-Confirm-DDL: true header.
+	ddlRateLimit      = envInt("DDL_RATE_LIMIT", 0)
+	ddlRequireConfirm = envBool("DDL_REQUIRE_CONFIRM", false)
+
+	// maxConcurrency caps DB-bound requests running at once (0 = unlimited).
+	// Up to queueSize more wait for a slot for at most queueTimeout before
+	// being rejected with 503.
+	maxConcurrency = envInt("MAX_CONCURRENCY", 0)
+	queueSize      = envInt("QUEUE_SIZE", 100)
+	queueTimeout   = envDuration("QUEUE_TIMEOUT", 5*time.Second)
+
+	// rewriteRulesFile names a JSON file of regex rewrite rules (e.g. to
+	// inject index hints) applied to matching queries before execution.
+	rewriteRulesFile = envString("REWRITE_RULES_FILE", "")
+
+	// maxJoins, maxSubqueryDepth and maxUnions reject statements with more
+	// JOINs, deeper nested subqueries or more UNIONs before they run.
+	// Each is off at 0.
+	maxJoins         = envInt("MAX_JOINS", 0)
+	maxSubqueryDepth = envInt("MAX_SUBQUERY_DEPTH", 0)
+	maxUnions        = envInt("MAX_UNIONS", 0)
+
+	// auditLogPath, when set, receives a JSON line per /query statement.
+	auditLogPath = envString("AUDIT_LOG", "")
+
+	// auditArgs adds each statement's bound args to its audit entry. Args
+	// bound to sensitive columns are masked, as are the 1-based positions
+	// in auditRedactArgs: "n" for every statement or "label:n" for those
+	// with that label (a named query's label is its name by default).
+	auditArgs       = envBool("AUDIT_ARGS", false)
+	auditRedactArgs = envLabelPositions("AUDIT_REDACT_ARGS")
+
+	// slowQueryThreshold logs statements that take at least this long (0
+	// disables it); slowQueryExplain adds the plan of slow SELECTs.
+	slowQueryThreshold = envDuration("SLOW_QUERY_THRESHOLD", 0)
+	slowQueryExplain   = envBool("SLOW_QUERY_EXPLAIN", false)
+
+	// maxTxStatements caps the statements in one /transaction request
+	// (0 = unlimited); txTimeout bounds how long its transaction may stay
+	// open before being rolled back.
+	maxTxStatements = envInt("MAX_TX_STATEMENTS", 100)
+	txTimeout       = envDuration("TX_TIMEOUT", 30*time.Second)
+
+	// txRetries is how many times a /transaction that hit a deadlock or
+	// lock wait timeout is replayed, after a jittered backoff starting at
+	// txRetryBackoff and doubling per attempt. Retries share TX_TIMEOUT.
+	txRetries      = envInt("TX_RETRIES", 2)
+	txRetryBackoff = envDuration("TX_RETRY_BACKOFF", 50*time.Millisecond)
+
+	// sessionIdleTimeout rolls back a /session transaction left unused this
+	// long; maxSessions caps how many may hold a connection at once
+	// (0 = unlimited).
+	sessionIdleTimeout = envDuration("SESSION_IDLE_TIMEOUT", time.Minute)
+	maxSessions        = envInt("MAX_SESSIONS", poolMaxOpen/2)
+
+	// maxOpenTransactions caps sessions and /transaction batches open at
+	// once, together, so they can't take over the pool (0 = unlimited).
+	maxOpenTransactions = envInt("MAX_OPEN_TRANSACTIONS", 0)
+
+	// cursorPageSize is the default and largest page a /cursor returns;
+	// cursorIdleTimeout closes cursors left unread, and maxCursors caps
+	// how many may hold a connection at once (0 = unlimited).
+	cursorPageSize    = envInt("CURSOR_PAGE_SIZE", 1000)
+	cursorIdleTimeout = envDuration("CURSOR_IDLE_TIMEOUT", time.Minute)
+	maxCursors        = envInt("MAX_CURSORS", poolMaxOpen/4)
+
+	// maxSubscriptions caps /subscribe streams, each of which holds a pool
+	// connection for as long as it stays open (0 = unlimited).
+	maxSubscriptions = envInt("MAX_SUBSCRIPTIONS", poolMaxOpen/4)
+
+	// webhookAllowedHosts lists the hosts a callbackUrl may point at; with
+	// none, callbacks are disabled. Failed deliveries are retried up to
+	// webhookRetries times with exponential backoff.
+	webhookAllowedHosts = envList("WEBHOOK_ALLOWED_HOSTS")
+	webhookRetries      = envInt("WEBHOOK_RETRIES", 5)
+
+	// jobRetention is how long a finished callback job's status stays
+	// available at /query/async/{id}.
+	jobRetention = envDuration("JOB_RETENTION", time.Hour)
+
+	// maxAsyncJobs caps callback jobs running or awaiting webhook delivery
+	// at once, each holding its response in memory (0 = unlimited).
+	maxAsyncJobs = envInt("MAX_ASYNC_JOBS", 20)
+
+	// outputTimezone, e.g. Europe/Berlin, converts DATETIME/TIMESTAMP
+	// values to that zone before they are serialized. Zone-less DATETIMEs
+	// are taken to be in the DSN's loc= on MySQL (UTC unless set), with or
+	// without parseTime=true, so loc= should match the server's time_zone.
+	// DATE values are never shifted.
+	outputTimezone = envLocation("OUTPUT_TIMEZONE")
+
+	// maxResponseBytes caps the encoded size of result rows (0 = no cap).
+	// Buffered responses fail with 400; streamed ones stop and are marked
+	// truncated.
+	maxResponseBytes = envInt("MAX_RESPONSE_BYTES", 0)
+
+	// maxCellBytes cuts text cells longer than this many bytes (binary
+	// ones by their encoded length) and flags the response with
+	// truncatedCells. 0 = no limit.
+	maxCellBytes = envInt("MAX_CELL_BYTES", 0)
+
+	// getQueries enables GET /query?sql=...&arg=... for SELECTs. The SQL
+	// then appears in URLs and access logs, so it is off by default. Such
+	// queries are capped at getQueryMaxLength bytes of SQL and
+	// getQueryRateLimit requests per minute per caller (0 = unlimited).
+	getQueries        = envBool("GET_QUERIES", false)
+	getQueryMaxLength = envInt("GET_QUERY_MAX_LENGTH", 2048)
+	getQueryRateLimit = envInt("GET_QUERY_RATE_LIMIT", 60)
+
+	// queryTimeout bounds each /query statement (0 = no limit); exceeding
+	// it answers 504.
+	queryTimeout = envDuration("QUERY_TIMEOUT", 0)
+
+	// connAcquireTimeout bounds the wait for a pooled connection (0 = no
+	// limit); running out of it answers 503 rather than 504.
+	connAcquireTimeout = envDuration("CONN_ACQUIRE_TIMEOUT", 0)
+
+	// dbStatementTimeout has the database abort statements running longer
+	// (MySQL: SELECTs only) instead of only abandoning the wait as
+	// QUERY_TIMEOUT does. 0 disables it; requests may ask for another
+	// value with timeoutMs, capped at dbStatementTimeoutMax.
+	dbStatementTimeout    = envDuration("DB_STATEMENT_TIMEOUT", 0)
+	dbStatementTimeoutMax = envDuration("DB_STATEMENT_TIMEOUT_MAX", 10*time.Minute)
+
+	// debugLogging enables debug-level log lines, such as requests whose
+	// client disconnected.
+	debugLogging = envBool("DEBUG", false)
+
+	// logBodies logs request and response bodies, redacted and cut to
+	// logBodyMaxBytes, for debugging client integrations.
+	logBodies       = envBool("LOG_BODIES", false)
+	logBodyMaxBytes = envInt("LOG_BODY_MAX_BYTES", 4096)
+
+	// connMaxIdleTime closes pooled connections left idle this long, e.g.
+	// below MySQL's wait_timeout so the server never drops one first.
+	// connValidateInterval, when set, pings the idle connections that
+	// often and evicts dead ones. 0 disables either.
+	connMaxIdleTime      = envDuration("CONN_MAX_IDLE_TIME", 0)
+	connValidateInterval = envDuration("CONN_VALIDATE_INTERVAL", 0)
+
+	// warmupQuery, e.g. SELECT 1, is run at startup on as many connections
+	// as the pool keeps idle, to take cold-start costs off the first
+	// requests.
+	warmupQuery = envString("WARMUP_QUERY", "")
+
+	// errorFormat selects the error body: "default" (ErrorResponse) or
+	// "problem" for RFC 7807 application/problem+json.
+	errorFormat = envEnum("ERROR_FORMAT", errorFormatDefault, errorFormatDefault, errorFormatProblem)
+
+	// queryWindows ("22:00-06:00,12:00-13:00", in queryWindowTZ) limits
+	// when statements may run; outside them the request gets 403 naming the
+	// next window. queryWindowTypes and queryWindowKeys restrict the rule
+	// to some statement types or API keys.
+	queryWindowSpecs = envList("QUERY_WINDOWS")
+	queryWindowTZ    = envLocationDefault("QUERY_WINDOW_TZ", time.UTC)
+	queryWindowTypes = envList("QUERY_WINDOW_TYPES")
+	queryWindowKeys  = envList("QUERY_WINDOW_KEYS")
+
+	// queryLabelLimit is how many distinct client query labels become
+	// metric label values; later ones are reported as "other".
+	queryLabelLimit = envInt("QUERY_LABEL_LIMIT", 100)
+
+	// queryFingerprintLimit is how many distinct query fingerprints become
+	// metric label values; later ones are reported as "other".
+	queryFingerprintLimit = envInt("QUERY_FINGERPRINT_LIMIT", 500)
+
+	// logSQLMaxLength cuts SQL written to the audit log and traces to this
+	// many characters (0 keeps it whole); logSQLStripComments drops
+	// comments from it first. The executed statement is untouched.
+	logSQLMaxLength     = envInt("LOG_SQL_MAX_LENGTH", 0)
+	logSQLStripComments = envBool("LOG_SQL_STRIP_COMMENTS", false)
+
+	// zeroDateMode decides how MySQL's 0000-00-00 dates are returned: as
+	// null (default), as the literal string, or as zeroDateSentinelValue.
+	zeroDateMode          = envEnum("ZERO_DATE", zeroDateNull, zeroDateNull, zeroDateString, zeroDateSentinel)
+	zeroDateSentinelValue = envString("ZERO_DATE_SENTINEL", "")
+
+	// jsonpEnabled wraps JSON responses to GET requests carrying
+	// ?callback= in that function call, for clients that can't use CORS.
+	// Any page can then read those responses, so only enable it where
+	// that is acceptable.
+	jsonpEnabled = envBool("JSONP", false)
+
+	// loadDataEnabled registers /load for CSV uploads via LOAD DATA LOCAL
+	// INFILE (MySQL only); loadDataMaxBytes caps one upload.
+	loadDataEnabled  = envBool("LOAD_DATA", false)
+	loadDataMaxBytes = int64(envInt("LOAD_DATA_MAX_BYTES", 1<<30))
+
+	// runMigrationsOnBoot applies MIGRATIONS_DIR/*.sql before serving.
+	runMigrationsOnBoot = envBool("RUN_MIGRATIONS", false)
+	migrationsDir       = envString("MIGRATIONS_DIR", "migrations")
+
+	// rlsMode makes Postgres queries run as the caller's database identity
+	// from API_KEY_USERS ("key:user,..."): "role" issues SET LOCAL ROLE,
+	// "setting" sets rlsSettingName for RLS policies. Empty disables it.
+	rlsMode        = envEnum("RLS_MODE", "", "", rlsRole, rlsSetting)
+	rlsSettingName = envString("RLS_SETTING", "app.current_user")
+	apiKeyUsers    = envStringMap("API_KEY_USERS")
+
+	// trustedProxies lists the CIDRs (or single IPs) allowed to set
+	// X-Forwarded-For / X-Real-IP.
+	trustedProxies = envPrefixes("TRUSTED_PROXIES")
+
+	// otlpEndpoint enables span export when set, e.g.
+	// http://otel-collector:4318. Trace context is propagated either way.
+	otlpEndpoint = envString("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+
+	// responseNaming selects the JSON field style of response envelopes:
+	// camelCase (default) or snake_case. Column names are never rewritten.
+	responseNaming = envEnum("RESPONSE_NAMING", namingCamel, namingCamel, namingSnake)
+
+	// schemaValidation loads a snapshot of the tables and columns at
+	// startup and registers /validate, which checks statements against it
+	// without a database round trip. schemaRefreshInterval reloads it that
+	// often (0 keeps the startup snapshot).
+	schemaValidation      = envBool("SCHEMA_VALIDATION", false)
+	schemaRefreshInterval = envDuration("SCHEMA_REFRESH_INTERVAL", 0)
+)
+
+// sqlDriverName maps a DB_DRIVER value to the registered database/sql
+// driver.
+func sqlDriverName(driver string) string {
+	if driver == driverPostgres {
+		return "pgx"
+	}
+	return "mysql"
+}
+
+// ---- ENV HELPERS ----
+
+func envString(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func envBool(key string, def bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Fatalf("invalid %s=%q: %v", key, v, err)
+	}
+	return b
+}
+
+func envInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Fatalf("invalid %s=%q: %v", key, v, err)
+	}
+	return n
+}
+
+func envEnum(key, def string, allowed ...string) string {
+	v := envString(key, def)
+	for _, a := range allowed {
+		if v == a {
+			return v
+		}
+	}
+	log.Fatalf("invalid %s=%q: must be one of %v", key, v, allowed)
+	return ""
+}
+
+// envList parses a comma-separated list, ignoring empty entries.
+func envList(key string) []string {
+	var out []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// envStringMap parses "name:value,name:value" pairs.
+func envStringMap(key string) map[string]string {
+	out := map[string]string{}
+	for _, item := range envList(key) {
+		name, val, ok := strings.Cut(item, ":")
+		if !ok {
+			log.Fatalf("invalid %s entry %q: want name:value", key, item)
+		}
+		out[name] = val
+	}
+	return out
+}
+
+// envIntMap parses "name:n,name:n" pairs.
+func envIntMap(key string) map[string]int {
+	out := map[string]int{}
+	for _, item := range envList(key) {
+		name, val, ok := strings.Cut(item, ":")
+		n, err := strconv.Atoi(val)
+		if !ok || err != nil {
+			log.Fatalf("invalid %s entry %q: want name:number", key, item)
+		}
+		out[name] = n
+	}
+	return out
+}
+
+// envLabelPositions parses "n" and "label:n" entries into positions by
+// label, "" holding the ones without a label.
+func envLabelPositions(key string) map[string]map[int]bool {
+	out := map[string]map[int]bool{}
+	for _, item := range envList(key) {
+		label, val, ok := strings.Cut(item, ":")
+		if !ok {
+			label, val = "", item
+		}
+		n, err := strconv.Atoi(val)
+		if err != nil || n < 1 {
+			log.Fatalf("invalid %s entry %q: want n or label:n, n from 1", key, item)
+		}
+		if out[label] == nil {
+			out[label] = map[int]bool{}
+		}
+		out[label][n] = true
+	}
+	return out
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalf("invalid %s=%q: %v", key, v, err)
+	}
+	return d
+}
+
+// envLocation loads an IANA time zone name; unset means nil.
+func envLocation(key string) *time.Location {
+	return envLocationDefault(key, nil)
+}
+
+func envLocationDefault(key string, def *time.Location) *time.Location {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	loc, err := time.LoadLocation(v)
+	if err != nil {
+		log.Fatalf("invalid %s=%q: %v", key, v, err)
+	}
+	return loc
+}
+
+// envPrefixes parses a comma-separated list of CIDRs; bare IPs are
+// treated as single-host prefixes.
+func envPrefixes(key string) []netip.Prefix {
+	var out []netip.Prefix
+	for _, item := range envList(key) {
+		if !strings.Contains(item, "/") {
+			addr, err := netip.ParseAddr(item)
+			if err != nil {
+				log.Fatalf("invalid %s entry %q: %v", key, item, err)
+			}
+			out = append(out, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(item)
+		if err != nil {
+			log.Fatalf("invalid %s entry %q: %v", key, item, err)
+		}
+		out = append(out, p.Masked())
+	}
+	return out
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ---- EXPLAIN COST ----
+
+var errCostUnsupported = errors.New("the database did not report a plan cost")
+
+type CostResponse struct {
+	Cost float64 `json:"cost"`
+}
+
+// explainCostHandler returns the planner's total cost for a SELECT as a
+// single number, so query variants can be compared without reading plans.
+// Costs are only comparable on the same database and driver.
+func explainCostHandler(w http.ResponseWriter, r *http.Request) {
+	var req QueryRequest
+	if berr := decodeJSONBody(w, r, &req); berr != nil {
+		respondJSON(w, r, berr.status, ErrorResponse{
+			Error: berr.msg,
+		})
+		return
+	}
+
+	if t := statementType(req.SQL); t != "" && t != "SELECT" {
+		respondJSON(w, r, http.StatusBadRequest, ErrorResponse{
+			Error: "Only SELECT statements can be costed",
+		})
+		return
+	}
+	bound, ok := bindTxStatement(w, r, TxStatement{SQL: req.SQL, Args: req.Args, ArgTypes: req.ArgTypes}, nil)
+	if !ok {
+		return
+	}
+
+	ctx, span := startDBSpan(r.Context(), "EXPLAIN", bound.sql)
+	defer span.End()
+	r = r.WithContext(ctx)
+
+	q, done, ok := readAsCaller(w, r, bound.queryType)
+	if !ok {
+		return
+	}
+	defer done()
+
+	cost, err := queryCost(ctx, q, bound.sql, bound.args)
+	if errors.Is(err, errCostUnsupported) {
+		respondJSON(w, r, http.StatusNotImplemented, ErrorResponse{
+			Error:     "Cost unsupported",
+			Message:   err.Error(),
+			RequestID: requestID(r),
+		})
+		return
+	}
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	respondJSON(w, r, http.StatusOK, CostResponse{Cost: cost})
+}
+
+// queryCost runs a JSON EXPLAIN and extracts the root cost: MySQL's
+// query_block.cost_info.query_cost, or the top plan's "Total Cost" on
+// Postgres. Servers that omit it (MariaDB, older MySQL) yield
+// errCostUnsupported.
+func queryCost(ctx context.Context, q queryer, sqlQuery string, args []interface{}) (float64, error) {
+	explain := "EXPLAIN FORMAT=JSON "
+	if dbDriver == driverPostgres {
+		explain = "EXPLAIN (FORMAT JSON) "
+	}
+
+	rows, err := q.QueryContext(ctx, explain+sqlQuery, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var raw []byte
+	if rows.Next() {
+		if err := rows.Scan(&raw); err != nil {
+			return 0, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if dbDriver == driverPostgres {
+		var plans []struct {
+			Plan struct {
+				TotalCost *float64 `json:"Total Cost"`
+			} `json:"Plan"`
+		}
+		if err := json.Unmarshal(raw, &plans); err != nil || len(plans) == 0 || plans[0].Plan.TotalCost == nil {
+			return 0, errCostUnsupported
+		}
+		return *plans[0].Plan.TotalCost, nil
+	}
+
+	var plan struct {
+		QueryBlock struct {
+			CostInfo struct {
+				QueryCost json.RawMessage `json:"query_cost"`
+			} `json:"cost_info"`
+		} `json:"query_block"`
+	}
+	if err := json.Unmarshal(raw, &plan); err != nil || plan.QueryBlock.CostInfo.QueryCost == nil {
+		return 0, errCostUnsupported
+	}
+	// MySQL reports the cost as a quoted decimal string.
+	cost, err := strconv.ParseFloat(strings.Trim(string(plan.QueryBlock.CostInfo.QueryCost), `"`), 64)
+	if err != nil {
+		return 0, errCostUnsupported
+	}
+	return cost, nil
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ---- CURSORS ----
+
+// cursor holds an open result on its own connection so a client can page
+// through it without OFFSET. mu serialises the requests reading it.
+type cursor struct {
+	id       string
+	key      string
+	conn     *sql.Conn
+	tx       *sql.Tx // only with RLS_MODE
+	rows     *sql.Rows
+	scanner  *rowScanner
+	cancel   context.CancelFunc
+	idle     *time.Timer
+	pageSize int
+	rowLimit int
+	read     int
+
+	mu     sync.Mutex
+	closed bool
+}
+
+type CursorRequest struct {
+	TxStatement
+	PageSize int `json:"pageSize,omitempty"`
+}
+
+type CursorResponse struct {
+	CursorID    string       `json:"cursorId"`
+	Columns     []ColumnInfo `json:"columns"`
+	PageSize    int          `json:"pageSize"`
+	IdleTimeout string       `json:"idleTimeout"`
+}
+
+// CursorPage is one page of a cursor. Done means the cursor is exhausted
+// and has been closed; Truncated that it stopped at the key's row limit.
+type CursorPage struct {
+	Rows      []map[string]interface{} `json:"rows"`
+	Count     int                      `json:"count"`
+	Done      bool                     `json:"done"`
+	Truncated bool                     `json:"truncated,omitempty"`
+
+	// TruncatedCells reports that cells on this page were cut to
+	// MAX_CELL_BYTES.
+	TruncatedCells bool `json:"truncatedCells,omitempty"`
+}
+
+// cursorsOpening counts cursors being opened; see reserveSlot.
+var (
+	cursorsMu      sync.Mutex
+	cursors        = map[string]*cursor{}
+	cursorsOpening int
+)
+
+// close releases the result, transaction and connection. It is a no-op
+// once the cursor is closed.
+func (c *cursor) close() {
+	if c.closed {
+		return
+	}
+	c.closed = true
+	c.idle.Stop()
+	cursorsMu.Lock()
+	delete(cursors, c.id)
+	cursorsMu.Unlock()
+
+	if c.rows != nil {
+		_ = c.rows.Close()
+	}
+	if c.tx != nil {
+		_ = c.tx.Rollback()
+	}
+	c.cancel()
+	_ = c.conn.Close()
+}
+
+// expire closes a cursor nobody has read for CURSOR_IDLE_TIMEOUT.
+func (c *cursor) expire() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		log.Printf("cursor %s idle for %s, closing", c.id, cursorIdleTimeout)
+		c.close()
+	}
+}
+
+// closeCursors closes every open cursor, for shutdown.
+func closeCursors() {
+	cursorsMu.Lock()
+	open := make([]*cursor, 0, len(cursors))
+	for _, c := range cursors {
+		open = append(open, c)
+	}
+	cursorsMu.Unlock()
+	for _, c := range open {
+		c.mu.Lock()
+		c.close()
+		c.mu.Unlock()
+	}
+}
+
+// cursorHandler serves /cursor/{id}/next and /cursor/{id}/close for a
+// cursor opened with /cursor/open. A cursor runs its query once and hands out pages of
+// at most CURSOR_PAGE_SIZE rows until the result is exhausted, it is
+// closed, or it is left unread for CURSOR_IDLE_TIMEOUT.
+func cursorHandler(w http.ResponseWriter, r *http.Request) {
+	id, action := r.PathValue("id"), r.PathValue("action")
+
+	cursorsMu.Lock()
+	c := cursors[id]
+	cursorsMu.Unlock()
+	if c == nil || c.key != apiKey(r) {
+		respondJSON(w, r, http.StatusNotFound, ErrorResponse{
+			Error:   "Cursor not found",
+			Message: "the cursor does not exist, is exhausted, or was closed after being idle",
+		})
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		respondJSON(w, r, http.StatusNotFound, ErrorResponse{
+			Error:   "Cursor not found",
+			Message: "the cursor has been closed",
+		})
+		return
+	}
+
+	switch action {
+	case "next":
+		c.idle.Reset(cursorIdleTimeout)
+		page, err := c.next()
+		if err != nil {
+			c.close()
+			respondErr(w, r, err)
+			return
+		}
+		respondJSON(w, r, http.StatusOK, page)
+	case "close":
+		c.close()
+		respondJSON(w, r, http.StatusOK, StatusResponse{Status: "closed"})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// next reads the following page, closing the cursor after the last one.
+func (c *cursor) next() (CursorPage, error) {
+	page := CursorPage{Rows: []map[string]interface{}{}}
+	c.scanner.truncatedCells = false
+	for len(page.Rows) < c.pageSize {
+		if !c.rows.Next() {
+			if err := c.rows.Err(); err != nil {
+				return page, err
+			}
+			page.Done = true
+			break
+		}
+		if c.rowLimit > 0 && c.read >= c.rowLimit {
+			page.Done, page.Truncated = true, true
+			break
+		}
+		row, err := c.scanner.scan(c.rows)
+		if err != nil {
+			return page, err
+		}
+		page.Rows = append(page.Rows, row)
+		c.read++
+	}
+	page.Count = len(page.Rows)
+	page.TruncatedCells = c.scanner.truncatedCells
+	if page.Done {
+		c.close()
+	}
+	return page, nil
+}
+
+func openCursor(w http.ResponseWriter, r *http.Request) {
+	var req CursorRequest
+	if berr := decodeJSONBody(w, r, &req); berr != nil {
+		respondJSON(w, r, berr.status, ErrorResponse{
+			Error: berr.msg,
+		})
+		return
+	}
+	bound, ok := bindTxStatement(w, r, req.TxStatement, nil)
+	if !ok {
+		return
+	}
+	if !isReadQuery(bound.queryType) {
+		respondJSON(w, r, http.StatusBadRequest, ErrorResponse{
+			Error: "Cursors need a row-returning statement",
+		})
+		return
+	}
+	pageSize := req.PageSize
+	if pageSize <= 0 || pageSize > cursorPageSize {
+		pageSize = cursorPageSize
+	}
+
+	place, ok := reserveSlot(&cursorsMu, &cursorsOpening, func() int { return len(cursors) }, maxCursors)
+	if !ok {
+		w.Header().Set("Retry-After", "1")
+		respondJSON(w, r, http.StatusServiceUnavailable, ErrorResponse{
+			Error:     "Too many cursors",
+			Message:   fmt.Sprintf("%d cursors are already open", maxCursors),
+			RequestID: requestID(r),
+		})
+		return
+	}
+	defer place.release()
+
+	conn, ok := acquireConn(w, r, poolFor(bound.queryType))
+	if !ok {
+		return
+	}
+
+	// The result is read across requests, so the query runs under a
+	// context of its own that is cancelled when the cursor closes.
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &cursor{
+		id:       newRequestID(),
+		key:      apiKey(r),
+		conn:     conn,
+		cancel:   cancel,
+		pageSize: pageSize,
+		rowLimit: rowLimitFor(apiKey(r)),
+	}
+	c.idle = time.AfterFunc(cursorIdleTimeout, c.expire)
+
+	var q queryer = conn
+	if rlsMode != "" {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			c.close()
+			respondErr(w, r, err)
+			return
+		}
+		c.tx, q = tx, tx
+		user, err := dbIdentityFor(c.key)
+		if err == nil {
+			err = applyDBIdentity(ctx, tx, user)
+		}
+		if err != nil {
+			c.close()
+			if errors.Is(err, errNoDBIdentity) {
+				respondJSON(w, r, http.StatusForbidden, ErrorResponse{
+					Error:   "Forbidden",
+					Message: err.Error(),
+				})
+				return
+			}
+			respondErr(w, r, err)
+			return
+		}
+	}
+
+	rows, err := q.QueryContext(ctx, tagQuery(r, bound.sql), bound.args...)
+	if err != nil {
+		c.close()
+		respondErr(w, r, err)
+		return
+	}
+	c.rows = rows
+	colTypes, err := rows.ColumnTypes()
+	if err != nil {
+		c.close()
+		respondErr(w, r, err)
+		return
+	}
+	if maxColumns > 0 && len(colTypes) > maxColumns {
+		c.close()
+		respondJSON(w, r, http.StatusBadRequest, ErrorResponse{
+			Error:   "Too many columns",
+			Message: fmt.Sprintf("result has %d columns, limit is %d", len(colTypes), maxColumns),
+		})
+		return
+	}
+	c.scanner = newRowScanner(colTypes)
+
+	cursorsMu.Lock()
+	cursors[c.id] = c
+	place.fill()
+	cursorsMu.Unlock()
+
+	respondJSON(w, r, http.StatusOK, CursorResponse{
+		CursorID:    c.id,
+		Columns:     c.scanner.columnInfo(),
+		PageSize:    pageSize,
+		IdleTimeout: cursorIdleTimeout.String(),
+	})
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// ---- ERROR CODES ----
+
+// Error codes reported in ErrorResponse.code. They are the same on MySQL
+// and Postgres, so clients can branch on them without parsing driver
+// messages. Treat the list as an API: add codes, don't rename them.
+const (
+	codeUniqueViolation      = "UNIQUE_VIOLATION"
+	codeFKViolation          = "FK_VIOLATION"
+	codeNotNullViolation     = "NOT_NULL_VIOLATION"
+	codeCheckViolation       = "CHECK_VIOLATION"
+	codeSyntaxError          = "SYNTAX_ERROR"
+	codeUndefinedTable       = "UNDEFINED_TABLE"
+	codeUndefinedColumn      = "UNDEFINED_COLUMN"
+	codePermissionDenied     = "PERMISSION_DENIED"
+	codeLockTimeout          = "LOCK_TIMEOUT"
+	codeDeadlock             = "DEADLOCK"
+	codeSerializationFailure = "SERIALIZATION_FAILURE"
+	codeStatementTimeout     = "STATEMENT_TIMEOUT"
+	codeDataTooLong          = "DATA_TOO_LONG"
+	codeInvalidValue         = "INVALID_VALUE"
+	codeDivisionByZero       = "DIVISION_BY_ZERO"
+	codeDBError              = "DB_ERROR"
+	codeDBUnavailable        = "DB_UNAVAILABLE"
+)
+
+var mysqlErrorCodes = map[uint16]string{
+	1062: codeUniqueViolation,
+	1586: codeUniqueViolation,
+	1216: codeFKViolation,
+	1217: codeFKViolation,
+	1451: codeFKViolation,
+	1452: codeFKViolation,
+	1048: codeNotNullViolation,
+	1364: codeNotNullViolation,
+	3819: codeCheckViolation,
+	1064: codeSyntaxError,
+	1149: codeSyntaxError,
+	1146: codeUndefinedTable,
+	1054: codeUndefinedColumn,
+	1044: codePermissionDenied,
+	1142: codePermissionDenied,
+	1143: codePermissionDenied,
+	1406: codeDataTooLong,
+	1264: codeInvalidValue,
+	1292: codeInvalidValue,
+	1366: codeInvalidValue,
+	1365: codeDivisionByZero,
+
+	mysqlErrLockWaitTimeout:  codeLockTimeout,
+	mysqlErrDeadlock:         codeDeadlock,
+	mysqlErrMaxExecutionTime: codeStatementTimeout,
+}
+
+var pgErrorCodes = map[string]string{
+	"23505": codeUniqueViolation,
+	"23503": codeFKViolation,
+	"23502": codeNotNullViolation,
+	"23514": codeCheckViolation,
+	"42601": codeSyntaxError,
+	"42P01": codeUndefinedTable,
+	"42703": codeUndefinedColumn,
+	"42501": codePermissionDenied,
+	"40001": codeSerializationFailure,
+	"22001": codeDataTooLong,
+	"22003": codeInvalidValue,
+	"22007": codeInvalidValue,
+	"22008": codeInvalidValue,
+	"22P02": codeInvalidValue,
+	"22012": codeDivisionByZero,
+
+	pgLockNotAvailable: codeLockTimeout,
+	pgDeadlockDetected: codeDeadlock,
+	pgQueryCanceled:    codeStatementTimeout,
+}
+
+// errorCode maps a statement error to one of the codes above: DB_ERROR
+// for a database error without a specific code, DB_UNAVAILABLE when the
+// database couldn't be reached.
+func errorCode(err error) string {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return codeStatementTimeout
+	}
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) {
+		if code, ok := mysqlErrorCodes[myErr.Number]; ok {
+			return code
+		}
+		return codeDBError
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		if code, ok := pgErrorCodes[pgErr.Code]; ok {
+			return code
+		}
+		return codeDBError
+	}
+	if isDBUnavailable(err) {
+		return codeDBUnavailable
+	}
+	return ""
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// ---- DATABASE STATEMENT TIMEOUT ----
+
+// mysqlErrMaxExecutionTime is ER_QUERY_TIMEOUT, raised when
+// MAX_EXECUTION_TIME interrupts a SELECT; Postgres reports statement
+// timeouts as query_canceled.
+const (
+	mysqlErrMaxExecutionTime = 3024
+	pgQueryCanceled          = "57014"
+)
+
+// statementTimeoutFor returns how long the database should let a statement
+// run: the request's timeoutMs if given, otherwise DB_STATEMENT_TIMEOUT,
+// clamped to DB_STATEMENT_TIMEOUT_MAX. Zero means no limit.
+func statementTimeoutFor(requestedMs int) time.Duration {
+	timeout := dbStatementTimeout
+	if requestedMs > 0 {
+		timeout = time.Duration(requestedMs) * time.Millisecond
+	}
+	if dbStatementTimeoutMax > 0 && timeout > dbStatementTimeoutMax {
+		timeout = dbStatementTimeoutMax
+	}
+	return timeout
+}
+
+// applyStatementTimeout has the database itself abort the statement after
+// timeout, so its work stops rather than just our wait for it. MySQL only
+// enforces this for SELECT, via a MAX_EXECUTION_TIME hint added to
+// execSQL. Postgres gets statement_timeout: SET LOCAL inside a
+// transaction, otherwise on the session, with the returned func resetting
+// it before the connection goes back to the pool.
+func applyStatementTimeout(ctx context.Context, q queryer, inTx bool, queryType, execSQL string, timeout time.Duration) (string, func(), error) {
+	ms := timeout.Milliseconds()
+	if dbDriver != driverPostgres {
+		if queryType == "SELECT" {
+			execSQL = addMaxExecutionTimeHint(execSQL, ms)
+		}
+		return execSQL, func() {}, nil
+	}
+
+	if inTx {
+		_, err := q.ExecContext(ctx, fmt.Sprintf("SET LOCAL statement_timeout = %d", ms))
+		return execSQL, func() {}, err
+	}
+	if _, err := q.ExecContext(ctx, fmt.Sprintf("SET statement_timeout = %d", ms)); err != nil {
+		return execSQL, func() {}, err
+	}
+	return execSQL, func() {
+		_, _ = q.ExecContext(context.WithoutCancel(ctx), "RESET statement_timeout")
+	}, nil
+}
+
+// addMaxExecutionTimeHint puts MAX_EXECUTION_TIME after the first SELECT,
+// inside the statement's optimizer hint comment if it already has one,
+// since MySQL only reads the first.
+func addMaxExecutionTimeHint(sql string, ms int64) string {
+	hint := fmt.Sprintf("MAX_EXECUTION_TIME(%d)", ms)
+	tokens := tokenize(sql)
+	for i, t := range tokens {
+		if t.kind == tokSpace || t.kind == tokComment {
+			continue
+		}
+		if !t.is("SELECT") {
+			return sql
+		}
+		next := i + 1
+		for next < len(tokens) && tokens[next].kind == tokSpace {
+			next++
+		}
+		if next < len(tokens) && tokens[next].kind == tokComment && strings.HasPrefix(tokens[next].text, "/*+") {
+			tokens[next].text = "/*+ " + hint + tokens[next].text[3:]
+			return tokensText(tokens)
+		}
+		return tokensText(tokens[:i+1]) + " /*+ " + hint + " */" + tokensText(tokens[i+1:])
+	}
+	return sql
+}
+
+// isStatementTimeout reports whether the database aborted a statement for
+// running past its statement timeout.
+func isStatementTimeout(err error) bool {
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) {
+		return myErr.Number == mysqlErrMaxExecutionTime
+	}
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgQueryCanceled
+}
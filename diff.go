@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// ---- RESULT DIFF ----
+
+type DiffRequest struct {
+	A TxStatement `json:"a"`
+	B TxStatement `json:"b"`
+
+	// Key names the columns that identify a row in both results.
+	Key []string `json:"key"`
+}
+
+type RowChange struct {
+	Key map[string]interface{} `json:"key"`
+	A   map[string]interface{} `json:"a"`
+	B   map[string]interface{} `json:"b"`
+}
+
+type DiffResponse struct {
+	OnlyInA   []map[string]interface{} `json:"onlyInA"`
+	OnlyInB   []map[string]interface{} `json:"onlyInB"`
+	Changed   []RowChange              `json:"changed"`
+	Unchanged int                      `json:"unchanged"`
+}
+
+// diffHandler runs two SELECTs and reports how their rows differ, matching
+// rows by the key columns. Both results must fit within the caller's row
+// limit; a diff of a truncated result would be meaningless.
+func diffHandler(w http.ResponseWriter, r *http.Request) {
+	var req DiffRequest
+	if berr := decodeJSONBody(w, r, &req); berr != nil {
+		respondJSON(w, r, berr.status, ErrorResponse{
+			Error: berr.msg,
+		})
+		return
+	}
+	if len(req.Key) == 0 {
+		respondJSON(w, r, http.StatusBadRequest, ErrorResponse{
+			Error: "At least one key column is required",
+		})
+		return
+	}
+
+	var stmts [2]boundStatement
+	for i, st := range []TxStatement{req.A, req.B} {
+		bound, ok := bindTxStatement(w, r, st, &i)
+		if !ok {
+			return
+		}
+		if !isReadQuery(bound.queryType) {
+			respondJSON(w, r, http.StatusBadRequest, ErrorResponse{
+				Error:     "Only row-returning statements can be diffed",
+				Statement: &i,
+			})
+			return
+		}
+		stmts[i] = bound
+	}
+
+	ctx, span := startDBSpan(r.Context(), "DIFF", stmts[0].sql)
+	defer span.End()
+	r = r.WithContext(ctx)
+
+	if queryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, queryTimeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+
+	q, done, ok := readAsCaller(w, r, "SELECT")
+	if !ok {
+		return
+	}
+	defer done()
+
+	rowLimit := rowLimitFor(apiKey(r))
+	var results [2][]map[string]interface{}
+	for i, st := range stmts {
+		result, err := runTxStatement(ctx, q, tagQuery(r, st.sql), st.queryType, st.args, rowLimit)
+		if err != nil {
+			respondErr(w, r, fmt.Errorf("statement %d: %w", i, err))
+			return
+		}
+		sel := result.(SelectResponse)
+		if sel.Truncated {
+			respondJSON(w, r, http.StatusBadRequest, ErrorResponse{
+				Error:     "Result too large to diff",
+				Message:   fmt.Sprintf("result has more than %d rows", rowLimit),
+				Statement: &i,
+			})
+			return
+		}
+		results[i] = sel.Rows
+	}
+
+	diff, err := diffRows(results[0], results[1], req.Key)
+	if err != nil {
+		respondJSON(w, r, http.StatusBadRequest, ErrorResponse{
+			Error:   "Cannot match rows",
+			Message: err.Error(),
+		})
+		return
+	}
+	respondJSON(w, r, http.StatusOK, diff)
+}
+
+// diffRows matches a and b on the key columns, keeping each side's row
+// order. Rows compare by their JSON encoding, which sorts columns.
+func diffRows(a, b []map[string]interface{}, key []string) (DiffResponse, error) {
+	keyOf := func(side string, row map[string]interface{}) (string, error) {
+		values := make([]interface{}, len(key))
+		for i, col := range key {
+			v, ok := row[col]
+			if !ok {
+				return "", fmt.Errorf("result %s has no column %q", side, col)
+			}
+			values[i] = v
+		}
+		k, err := json.Marshal(values)
+		return string(k), err
+	}
+	index := func(side string, rows []map[string]interface{}) (map[string]map[string]interface{}, []string, error) {
+		byKey := make(map[string]map[string]interface{}, len(rows))
+		order := make([]string, 0, len(rows))
+		for _, row := range rows {
+			k, err := keyOf(side, row)
+			if err != nil {
+				return nil, nil, err
+			}
+			if _, dup := byKey[k]; dup {
+				return nil, nil, fmt.Errorf("key %s appears more than once in result %s", k, side)
+			}
+			byKey[k] = row
+			order = append(order, k)
+		}
+		return byKey, order, nil
+	}
+
+	byKeyA, orderA, err := index("a", a)
+	if err != nil {
+		return DiffResponse{}, err
+	}
+	byKeyB, orderB, err := index("b", b)
+	if err != nil {
+		return DiffResponse{}, err
+	}
+
+	diff := DiffResponse{
+		OnlyInA: []map[string]interface{}{},
+		OnlyInB: []map[string]interface{}{},
+		Changed: []RowChange{},
+	}
+	for _, k := range orderA {
+		rowA, rowB := byKeyA[k], byKeyB[k]
+		if rowB == nil {
+			diff.OnlyInA = append(diff.OnlyInA, rowA)
+			continue
+		}
+		encA, err := json.Marshal(rowA)
+		if err != nil {
+			return DiffResponse{}, err
+		}
+		encB, err := json.Marshal(rowB)
+		if err != nil {
+			return DiffResponse{}, err
+		}
+		if string(encA) == string(encB) {
+			diff.Unchanged++
+			continue
+		}
+		keyValues := make(map[string]interface{}, len(key))
+		for _, col := range key {
+			keyValues[col] = rowA[col]
+		}
+		diff.Changed = append(diff.Changed, RowChange{Key: keyValues, A: rowA, B: rowB})
+	}
+	for _, k := range orderB {
+		if byKeyA[k] == nil {
+			diff.OnlyInB = append(diff.OnlyInB, byKeyB[k])
+		}
+	}
+	return diff, nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// ---- ETAG ----
+
+// resultETag hashes the statement, its args and the response body, so the
+// tag changes whenever any of them would.
+func resultETag(sqlQuery string, args []interface{}, payload interface{}) (string, error) {
+	body, err := json.Marshal(struct {
+		SQL     string        `json:"sql"`
+		Args    []interface{} `json:"args"`
+		Payload interface{}   `json:"payload"`
+	}{sqlQuery, args, payload})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`, nil
+}
+
+// etagMatches implements If-None-Match's comparison against etag.
+func etagMatches(r *http.Request, etag string) bool {
+	for _, candidate := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
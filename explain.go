@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ---- EXPLAIN ----
+
+// jsonExplainPattern matches MySQL's EXPLAIN FORMAT=JSON and Postgres'
+// EXPLAIN (FORMAT JSON).
+var jsonExplainPattern = regexp.MustCompile(`(?is)^EXPLAIN\s+(?:ANALYZE\s+)?(?:FORMAT\s*=\s*JSON\b|\([^)]*\bFORMAT\s+JSON\b)`)
+
+func isJSONExplain(sqlQuery string) bool {
+	return jsonExplainPattern.MatchString(sqlQuery)
+}
+
+// parseExplainJSON replaces the JSON plan blob in each row with the parsed
+// document, so clients get a nested object rather than an escaped string.
+func parseExplainJSON(results []map[string]interface{}) {
+	for _, row := range results {
+		for col, v := range row {
+			if s, ok := v.(string); ok && json.Valid([]byte(s)) {
+				row[col] = json.RawMessage(s)
+			}
+		}
+	}
+}
+
+const (
+	costReject = "reject"
+	costWarn   = "warn"
+)
+
+// estimateRows asks the planner how many rows sqlQuery will examine. On
+// MySQL that is the product of the per-table "rows" estimates, which is
+// what a nested-loop join reads; on Postgres it is the sum of "Plan Rows"
+// over the plan tree. Both are rough, but good enough to catch cartesian
+// joins and unindexed scans.
+func estimateRows(ctx context.Context, q queryer, sqlQuery string, args []interface{}) (float64, error) {
+	if dbDriver == driverPostgres {
+		return estimateRowsPostgres(ctx, q, sqlQuery, args)
+	}
+
+	rows, err := q.QueryContext(ctx, "EXPLAIN "+sqlQuery, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	rowsIdx := -1
+	for i, col := range columns {
+		if strings.EqualFold(col, "rows") {
+			rowsIdx = i
+		}
+	}
+	if rowsIdx < 0 {
+		return 0, fmt.Errorf("EXPLAIN output has no rows column")
+	}
+
+	values := make([]interface{}, len(columns))
+	ptrs := make([]interface{}, len(columns))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+
+	estimate, found := 1.0, false
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return 0, err
+		}
+		n, err := strconv.ParseFloat(fmt.Sprint(convertRaw(values[rowsIdx])), 64)
+		if err != nil {
+			continue // NULL for rows that touch no table
+		}
+		estimate *= n
+		found = true
+	}
+	if !found {
+		return 0, rows.Err()
+	}
+	return estimate, rows.Err()
+}
+
+func estimateRowsPostgres(ctx context.Context, q queryer, sqlQuery string, args []interface{}) (float64, error) {
+	var raw string
+	rows, err := q.QueryContext(ctx, "EXPLAIN (FORMAT JSON) "+sqlQuery, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	if rows.Next() {
+		if err := rows.Scan(&raw); err != nil {
+			return 0, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var plans []struct {
+		Plan map[string]interface{} `json:"Plan"`
+	}
+	if err := json.Unmarshal([]byte(raw), &plans); err != nil || len(plans) == 0 {
+		return 0, fmt.Errorf("unexpected EXPLAIN output: %v", err)
+	}
+	return sumPlanRows(plans[0].Plan), nil
+}
+
+func sumPlanRows(node map[string]interface{}) float64 {
+	total, _ := node["Plan Rows"].(float64)
+	children, _ := node["Plans"].([]interface{})
+	for _, c := range children {
+		if child, ok := c.(map[string]interface{}); ok {
+			total += sumPlanRows(child)
+		}
+	}
+	return total
+}
+
+// convertRaw turns driver bytes into a string so they can be parsed.
+func convertRaw(v interface{}) interface{} {
+	if b, ok := v.([]byte); ok {
+		return string(b)
+	}
+	return v
+}
+
+// explainRows runs a plain EXPLAIN for sqlQuery on q and returns its rows.
+func explainRows(ctx context.Context, q queryer, sqlQuery string, args []interface{}) ([]map[string]interface{}, error) {
+	rows, err := q.QueryContext(ctx, "EXPLAIN "+sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	colTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	scanner := newRowScanner(colTypes)
+
+	plan := []map[string]interface{}{}
+	for rows.Next() {
+		row, err := scanner.scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		plan = append(plan, row)
+	}
+	return plan, rows.Err()
+}
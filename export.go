@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ---- CSV EXPORT ----
+
+// csvFlushEvery controls how often buffered CSV rows are pushed to the
+// client.
+const csvFlushEvery = 1000
+
+type ExportRequest struct {
+	SQL       string        `json:"sql"`
+	Args      []interface{} `json:"args,omitempty"`
+	ArgTypes  []string      `json:"argTypes,omitempty"`
+	Filename  string        `json:"filename"`
+	Delimiter string        `json:"delimiter"`
+}
+
+// csvDelimiters maps the accepted delimiter names to their runes.
+var csvDelimiters = map[string]rune{
+	"":          ',',
+	"comma":     ',',
+	",":         ',',
+	"tab":       '\t',
+	"\t":        '\t',
+	"semicolon": ';',
+	";":         ';',
+}
+
+var unsafeFilenameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// exportHandler streams a SELECT as a CSV attachment without buffering the
+// result. The row cap still applies; a trailing comment line notes when it
+// cut the export short.
+func exportHandler(w http.ResponseWriter, r *http.Request) {
+	var req ExportRequest
+	if berr := decodeJSONBody(w, r, &req); berr != nil {
+		respondJSON(w, r, berr.status, ErrorResponse{
+			Error: berr.msg,
+		})
+		return
+	}
+
+	if t := statementType(req.SQL); t != "" && !isReadQuery(t) {
+		respondJSON(w, r, http.StatusBadRequest, ErrorResponse{
+			Error: "Only row-returning statements can be exported",
+		})
+		return
+	}
+	bound, ok := bindTxStatement(w, r, TxStatement{SQL: req.SQL, Args: req.Args, ArgTypes: req.ArgTypes}, nil)
+	if !ok {
+		return
+	}
+	sqlQuery, queryType, args := bound.sql, bound.queryType, bound.args
+
+	delimiter, ok := csvDelimiters[strings.ToLower(req.Delimiter)]
+	if !ok {
+		respondJSON(w, r, http.StatusBadRequest, ErrorResponse{
+			Error: "delimiter must be comma, tab or semicolon",
+		})
+		return
+	}
+
+	ctx, span := startDBSpan(r.Context(), queryType, sqlQuery)
+	defer span.End()
+	r = r.WithContext(ctx)
+
+	q, done, ok := readAsCaller(w, r, queryType)
+	if !ok {
+		return
+	}
+	defer done()
+
+	rows, err := q.QueryContext(ctx, tagQuery(r, sqlQuery), args...)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	// The header row is written from the column metadata, so an export
+	// of no rows still names its columns.
+	colTypes, err := rows.ColumnTypes()
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+	columns := columnNames(colTypes)
+	if maxColumns > 0 && len(columns) > maxColumns {
+		respondJSON(w, r, http.StatusBadRequest, ErrorResponse{
+			Error:   "Too many columns",
+			Message: fmt.Sprintf("result has %d columns, limit is %d", len(columns), maxColumns),
+		})
+		return
+	}
+
+	rowLimit := rowLimitFor(apiKey(r))
+	if rowLimit > 0 {
+		w.Header().Set("X-Row-Limit", strconv.Itoa(rowLimit))
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, exportFilename(req.Filename)))
+	w.WriteHeader(http.StatusOK)
+
+	cw := csv.NewWriter(w)
+	cw.Comma = delimiter
+	_ = cw.Write(columns)
+
+	scanner := newRowScanner(colTypes)
+	count := 0
+	var sent int64
+	record := make([]string, len(columns))
+	for rows.Next() {
+		if rowLimit > 0 && count >= rowLimit {
+			_ = cw.Write([]string{fmt.Sprintf("# truncated: row limit of %d reached", rowLimit)})
+			break
+		}
+		if ctx.Err() != nil {
+			log.Printf("[%s] export cancelled after %d rows", requestID(r), count)
+			return
+		}
+
+		row, err := scanner.scan(rows)
+		if err != nil {
+			log.Printf("[%s] export aborted after %d rows: %v", requestID(r), count, err)
+			return
+		}
+		size := int64(len(record))
+		for i, col := range columns {
+			record[i] = csvCell(row[col])
+			size += int64(len(record[i]))
+		}
+		if sent += size; overByteLimit(sent) {
+			_ = cw.Write([]string{fmt.Sprintf("# truncated: size limit of %d bytes reached", maxResponseBytes)})
+			break
+		}
+		_ = cw.Write(record)
+
+		count++
+		if count%csvFlushEvery == 0 {
+			cw.Flush()
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Printf("[%s] export aborted after %d rows: %v", requestID(r), count, err)
+	}
+	cw.Flush()
+	setSpanRowCount(span, "db.rows_returned", int64(count))
+}
+
+// exportFilename sanitises a client-supplied name for Content-Disposition.
+func exportFilename(name string) string {
+	name = unsafeFilenameChars.ReplaceAllString(name, "_")
+	name = strings.Trim(name, "._")
+	if name == "" {
+		name = "export"
+	}
+	if !strings.HasSuffix(strings.ToLower(name), ".csv") {
+		name += ".csv"
+	}
+	return name
+}
+
+func csvCell(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return csvNull
+	case string:
+		return val
+	case []byte:
+		return string(val)
+	}
+	return fmt.Sprint(v)
+}
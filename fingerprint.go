@@ -0,0 +1,50 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// ---- QUERY FINGERPRINTS ----
+
+// normalizeSQL reduces a statement to its shape: literals and placeholders
+// become ?, comma-separated runs of them (IN lists, VALUES tuples) collapse
+// to a single ?, comments are dropped, unquoted words are upper-cased and
+// whitespace is canonical. Queries differing only in values normalize to
+// the same text.
+func normalizeSQL(sql string) string {
+	var out []string
+	for _, t := range significant(tokenize(sql)) {
+		text := t.text
+		switch t.kind {
+		case tokString, tokNumber, tokPlaceholder:
+			text = "?"
+		case tokWord:
+			text = strings.ToUpper(text)
+		}
+
+		n := len(out)
+		if text == "?" && n >= 2 && out[n-1] == "," && out[n-2] == "?" {
+			out = out[:n-1]
+			continue
+		}
+		out = append(out, text)
+	}
+
+	var b strings.Builder
+	for i, text := range out {
+		if i > 0 && out[i-1] != "(" && out[i-1] != "." && text != ")" && text != "," && text != "." {
+			b.WriteByte(' ')
+		}
+		b.WriteString(text)
+	}
+	return b.String()
+}
+
+// queryFingerprint is a short, stable identifier for a statement's
+// normalized form, cheap enough to use as a metric label.
+func queryFingerprint(sql string) string {
+	sum := sha256.Sum256([]byte(normalizeSQL(sql)))
+	return hex.EncodeToString(sum[:8])
+}
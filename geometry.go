@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ---- GEOMETRY ----
+
+var errUnsupportedGeometry = errors.New("unsupported geometry encoding")
+
+// mysqlGeometryToWKT decodes MySQL's internal geometry format: a 4-byte
+// little-endian SRID followed by standard WKB.
+func mysqlGeometryToWKT(b []byte) (string, error) {
+	if len(b) < 4 {
+		return "", errUnsupportedGeometry
+	}
+	return wkbToWKT(b[4:])
+}
+
+func wkbToWKT(b []byte) (string, error) {
+	r := &wkbReader{buf: b}
+	var sb strings.Builder
+	if err := r.geometry(&sb); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// wkbReader walks 2D WKB/EWKB. Z and M coordinates are not supported.
+type wkbReader struct {
+	buf   []byte
+	order binary.ByteOrder
+}
+
+func (r *wkbReader) take(n int) ([]byte, error) {
+	if len(r.buf) < n {
+		return nil, errUnsupportedGeometry
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b, nil
+}
+
+func (r *wkbReader) uint32() (uint32, error) {
+	b, err := r.take(4)
+	if err != nil {
+		return 0, err
+	}
+	return r.order.Uint32(b), nil
+}
+
+func (r *wkbReader) float() (float64, error) {
+	b, err := r.take(8)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(r.order.Uint64(b)), nil
+}
+
+// header reads the byte order and type, skipping an EWKB SRID.
+func (r *wkbReader) header() (uint32, error) {
+	bo, err := r.take(1)
+	if err != nil {
+		return 0, err
+	}
+	if bo[0] == 0 {
+		r.order = binary.BigEndian
+	} else {
+		r.order = binary.LittleEndian
+	}
+
+	typ, err := r.uint32()
+	if err != nil {
+		return 0, err
+	}
+	const ewkbZ, ewkbM, ewkbSRID = 0x80000000, 0x40000000, 0x20000000
+	if typ&(ewkbZ|ewkbM) != 0 || typ&0xffff > 7 {
+		return 0, errUnsupportedGeometry
+	}
+	if typ&ewkbSRID != 0 {
+		if _, err := r.uint32(); err != nil {
+			return 0, err
+		}
+	}
+	return typ & 0xffff, nil
+}
+
+func (r *wkbReader) geometry(sb *strings.Builder) error {
+	typ, err := r.header()
+	if err != nil {
+		return err
+	}
+
+	names := [...]string{"", "POINT", "LINESTRING", "POLYGON", "MULTIPOINT", "MULTILINESTRING", "MULTIPOLYGON", "GEOMETRYCOLLECTION"}
+	if typ == 0 {
+		return errUnsupportedGeometry
+	}
+	sb.WriteString(names[typ])
+
+	switch typ {
+	case 1:
+		x, err := r.float()
+		if err != nil {
+			return err
+		}
+		y, err := r.float()
+		if err != nil {
+			return err
+		}
+		if math.IsNaN(x) && math.IsNaN(y) {
+			sb.WriteString(" EMPTY")
+			return nil
+		}
+		fmt.Fprintf(sb, "(%s %s)", formatCoord(x), formatCoord(y))
+		return nil
+	case 2:
+		return r.points(sb)
+	case 3:
+		return r.rings(sb)
+	}
+
+	// Multi* and collections hold complete WKB geometries.
+	n, err := r.uint32()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		sb.WriteString(" EMPTY")
+		return nil
+	}
+	sb.WriteByte('(')
+	for i := uint32(0); i < n; i++ {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		var part strings.Builder
+		if err := r.geometry(&part); err != nil {
+			return err
+		}
+		text := part.String()
+		if typ != 7 {
+			// Inside MULTI* the member type name is implied.
+			if i := strings.IndexByte(text, '('); i >= 0 {
+				text = text[i:]
+			} else {
+				text = "EMPTY"
+			}
+		}
+		sb.WriteString(text)
+	}
+	sb.WriteByte(')')
+	return nil
+}
+
+func (r *wkbReader) points(sb *strings.Builder) error {
+	n, err := r.uint32()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		sb.WriteString(" EMPTY")
+		return nil
+	}
+	sb.WriteByte('(')
+	for i := uint32(0); i < n; i++ {
+		x, err := r.float()
+		if err != nil {
+			return err
+		}
+		y, err := r.float()
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(formatCoord(x) + " " + formatCoord(y))
+	}
+	sb.WriteByte(')')
+	return nil
+}
+
+func (r *wkbReader) rings(sb *strings.Builder) error {
+	n, err := r.uint32()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		sb.WriteString(" EMPTY")
+		return nil
+	}
+	sb.WriteByte('(')
+	for i := uint32(0); i < n; i++ {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		if err := r.points(sb); err != nil {
+			return err
+		}
+	}
+	sb.WriteByte(')')
+	return nil
+}
+
+func formatCoord(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
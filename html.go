@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// ---- HTML RESULTS ----
+
+// wantsHTML reports whether the client asked for an HTML page via
+// ?format=html or an Accept header preferring text/html.
+func wantsHTML(r *http.Request) bool {
+	if r.URL.Query().Get("format") == "html" {
+		return true
+	}
+	accept := r.Header.Get("Accept")
+	if i := strings.IndexByte(accept, ','); i >= 0 {
+		accept = accept[:i]
+	}
+	mediaType, _, _ := mime.ParseMediaType(accept)
+	return mediaType == "text/html"
+}
+
+// respondResult writes a successful query result as JSON or, when
+// requested, as an HTML page.
+func respondResult(w http.ResponseWriter, r *http.Request, payload interface{}) {
+	if !wantsHTML(r) {
+		respondJSON(w, r, http.StatusOK, payload)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := resultTemplate.Execute(&buf, htmlView(payload)); err != nil {
+		log.Printf("[%s] rendering HTML result: %v", requestID(r), err)
+		respondJSON(w, r, http.StatusOK, payload)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = buf.WriteTo(w)
+}
+
+type htmlPage struct {
+	Type    string
+	Columns []string
+	Rows    [][]htmlCell
+	Count   int
+	Fields  [][2]string // label/value pairs for non-SELECT results
+}
+
+type htmlCell struct {
+	Value string
+	Null  bool
+}
+
+func htmlView(payload interface{}) htmlPage {
+	switch p := payload.(type) {
+	case SelectResponse:
+		page := htmlPage{Type: p.Type, Count: p.Count}
+		for _, c := range p.Columns {
+			page.Columns = append(page.Columns, c.Name)
+		}
+		for _, row := range p.Rows {
+			cells := make([]htmlCell, len(page.Columns))
+			for i, col := range page.Columns {
+				cells[i] = htmlCellOf(row[col])
+			}
+			page.Rows = append(page.Rows, cells)
+		}
+		if p.Truncated {
+			page.Fields = append(page.Fields, [2]string{"Truncated", "yes"})
+		}
+		return page
+	case ExecResponse:
+		page := htmlPage{Type: p.Type, Fields: [][2]string{{"Affected rows", fmt.Sprint(p.AffectedRows)}}}
+		if p.InsertID != nil {
+			page.Fields = append(page.Fields, [2]string{"Insert ID", fmt.Sprint(*p.InsertID)})
+		}
+		return page
+	case DDLResponse:
+		return htmlPage{Type: p.Type, Fields: [][2]string{{"Status", p.Status}}}
+	}
+	return htmlPage{}
+}
+
+func htmlCellOf(v interface{}) htmlCell {
+	switch v := v.(type) {
+	case nil:
+		return htmlCell{Value: "NULL", Null: true}
+	case json.RawMessage:
+		return htmlCell{Value: string(v)}
+	default:
+		return htmlCell{Value: fmt.Sprint(v)}
+	}
+}
+
+// html/template escapes every cell, so result data can't inject markup.
+var resultTemplate = template.Must(template.New("result").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Type}} result</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 1.5em; }
+table { border-collapse: collapse; font-size: 0.9em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; vertical-align: top; }
+th { background: #f0f0f0; position: sticky; top: 0; }
+tr:nth-child(even) td { background: #fafafa; }
+td.null { color: #999; font-style: italic; }
+</style>
+</head>
+<body>
+<h1>{{.Type}}</h1>
+{{if .Columns}}<p>{{.Count}} row{{if ne .Count 1}}s{{end}}</p>
+<table>
+<tr>{{range .Columns}}<th>{{.}}</th>{{end}}</tr>
+{{range .Rows}}<tr>{{range .}}<td{{if .Null}} class="null"{{end}}>{{.Value}}</td>{{end}}</tr>
+{{end}}</table>
+{{end}}{{if .Fields}}<dl>
+{{range .Fields}}<dt>{{index . 0}}</dt><dd>{{index . 1}}</dd>
+{{end}}</dl>
+{{end}}</body>
+</html>
+`))
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ---- IDEMPOTENCY ----
+
+type idempotencyState int
+
+const (
+	idempotencyNew idempotencyState = iota
+	idempotencyReplay
+	idempotencyInProgress
+	idempotencyMismatch
+)
+
+type idempotencyEntry struct {
+	fingerprint [32]byte
+	done        bool
+	status      int
+	contentType string
+	body        []byte
+	expires     time.Time
+}
+
+// idempotencyStore remembers write responses by (API key, Idempotency-Key)
+// so a retried request is answered from memory instead of re-executed.
+type idempotencyStore struct {
+	mu      sync.Mutex
+	entries map[string]*idempotencyEntry
+}
+
+var idempotency = &idempotencyStore{entries: map[string]*idempotencyEntry{}}
+
+// begin claims scope for a new request, or reports why it can't: a stored
+// response is available, the original is still running, or the key was
+// reused for a different statement.
+func (s *idempotencyStore) begin(scope string, payload string) (*idempotencyEntry, idempotencyState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	fp := sha256.Sum256([]byte(payload))
+
+	if e, ok := s.entries[scope]; ok && now.Before(e.expires) {
+		switch {
+		case e.fingerprint != fp:
+			return nil, idempotencyMismatch
+		case !e.done:
+			return nil, idempotencyInProgress
+		default:
+			return e, idempotencyReplay
+		}
+	}
+
+	if len(s.entries) >= idempotencyMaxKeys {
+		s.evictLocked(now)
+	}
+	s.entries[scope] = &idempotencyEntry{fingerprint: fp, expires: now.Add(idempotencyTTL)}
+	return nil, idempotencyNew
+}
+
+// finish stores a successful response for replay. Failed attempts are
+// forgotten so the client can retry them.
+func (s *idempotencyStore) finish(scope string, rec *captureWriter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[scope]
+	if !ok {
+		return
+	}
+	if rec.status == 0 || rec.status >= http.StatusBadRequest {
+		delete(s.entries, scope)
+		return
+	}
+	e.done = true
+	e.status = rec.status
+	e.contentType = rec.Header().Get("Content-Type")
+	e.body = rec.body.Bytes()
+}
+
+// evictLocked drops expired entries and, if still full, the one closest
+// to expiry.
+func (s *idempotencyStore) evictLocked(now time.Time) {
+	var oldestKey string
+	var oldest time.Time
+	for k, e := range s.entries {
+		if !now.Before(e.expires) {
+			delete(s.entries, k)
+			continue
+		}
+		if oldestKey == "" || e.expires.Before(oldest) {
+			oldestKey, oldest = k, e.expires
+		}
+	}
+	if len(s.entries) >= idempotencyMaxKeys && oldestKey != "" {
+		delete(s.entries, oldestKey)
+	}
+}
+
+func replayResponse(w http.ResponseWriter, e *idempotencyEntry) {
+	w.Header().Set("Content-Type", e.contentType)
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(e.status)
+	_, _ = w.Write(e.body)
+}
+
+// captureWriter passes a response through while keeping a copy of it.
+type captureWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (c *captureWriter) WriteHeader(status int) {
+	c.status = status
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *captureWriter) Write(p []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	c.body.Write(p)
+	return c.ResponseWriter.Write(p)
+}
+
+func (c *captureWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
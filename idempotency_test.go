@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIdempotencyStore(t *testing.T) {
+	s := &idempotencyStore{entries: map[string]*idempotencyEntry{}}
+
+	if _, state := s.begin("k:1", "DELETE FROM t WHERE id = 1"); state != idempotencyNew {
+		t.Fatalf("first begin = %v, want idempotencyNew", state)
+	}
+	if _, state := s.begin("k:1", "DELETE FROM t WHERE id = 1"); state != idempotencyInProgress {
+		t.Errorf("begin while running = %v, want idempotencyInProgress", state)
+	}
+	if _, state := s.begin("k:1", "DELETE FROM t WHERE id = 2"); state != idempotencyMismatch {
+		t.Errorf("begin with another statement = %v, want idempotencyMismatch", state)
+	}
+
+	rec := &captureWriter{ResponseWriter: httptest.NewRecorder()}
+	rec.Header().Set("Content-Type", "application/json")
+	rec.WriteHeader(http.StatusOK)
+	_, _ = rec.Write([]byte(`{"affectedRows":1}`))
+	s.finish("k:1", rec)
+
+	e, state := s.begin("k:1", "DELETE FROM t WHERE id = 1")
+	if state != idempotencyReplay || string(e.body) != `{"affectedRows":1}` {
+		t.Errorf("begin after success = %v with body %q, want a replay", state, e.body)
+	}
+
+	// A failed attempt is forgotten so the client can retry it.
+	s.begin("k:2", "UPDATE t SET x = 1 WHERE id = 1")
+	failed := &captureWriter{ResponseWriter: httptest.NewRecorder()}
+	failed.WriteHeader(http.StatusInternalServerError)
+	s.finish("k:2", failed)
+	if _, state := s.begin("k:2", "UPDATE t SET x = 1 WHERE id = 1"); state != idempotencyNew {
+		t.Errorf("begin after a failure = %v, want idempotencyNew", state)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// ---- SERVER INFO ----
+
+type InfoResponse struct {
+	Driver     string      `json:"driver"`
+	Version    string      `json:"version"`
+	Database   interface{} `json:"database"`
+	ServerTime interface{} `json:"serverTime"`
+	SQLMode    interface{} `json:"sqlMode,omitempty"` // MySQL only
+}
+
+// serverVersion caches SELECT VERSION(), which can't change without a
+// restart that also drops our connections. Failures aren't cached.
+var serverVersion struct {
+	mu      sync.Mutex
+	version string
+}
+
+func cachedVersion(ctx context.Context) (string, error) {
+	serverVersion.mu.Lock()
+	defer serverVersion.mu.Unlock()
+	if serverVersion.version != "" {
+		return serverVersion.version, nil
+	}
+	var version string
+	if err := db.QueryRowContext(ctx, "SELECT VERSION()").Scan(&version); err != nil {
+		return "", err
+	}
+	serverVersion.version = version
+	return version, nil
+}
+
+// infoHandler reports what the runner is connected to: server version,
+// current database, server time and, on MySQL, the session SQL mode.
+func infoHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	version, err := cachedVersion(ctx)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	query := "SELECT DATABASE() AS `database`, NOW() AS serverTime, @@SESSION.sql_mode AS sqlMode"
+	if dbDriver == driverPostgres {
+		query = `SELECT current_database() AS "database", now() AS "serverTime"`
+	}
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	colTypes, err := rows.ColumnTypes()
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+	scanner := newRowScanner(colTypes)
+	row := map[string]interface{}{}
+	if rows.Next() {
+		if row, err = scanner.scan(rows); err != nil {
+			respondErr(w, r, err)
+			return
+		}
+	}
+	if err := rows.Err(); err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	respondJSON(w, r, http.StatusOK, InfoResponse{
+		Driver:     dbDriver,
+		Version:    version,
+		Database:   row["database"],
+		ServerTime: row["serverTime"],
+		SQLMode:    row["sqlMode"],
+	})
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ---- ASYNC JOBS ----
+
+const (
+	jobRunning   = "running"
+	jobCompleted = "completed"
+	jobCancelled = "cancelled"
+)
+
+type JobResponse struct {
+	JobID  string `json:"jobId"`
+	Status string `json:"status"`
+}
+
+// asyncJob tracks a background /query run. Finished jobs are remembered
+// for JOB_RETENTION so clients can still look them up.
+type asyncJob struct {
+	key    string
+	cancel context.CancelFunc
+
+	mu     sync.Mutex
+	status string
+}
+
+var (
+	jobsMu sync.Mutex
+	jobs   = map[string]*asyncJob{}
+)
+
+// activeJobs counts jobs running or delivering their webhook, for
+// MAX_ASYNC_JOBS.
+var activeJobs atomic.Int64
+
+// reserveJob counts a new job as active, or reports false when
+// MAX_ASYNC_JOBS are already. Every true must be matched by a releaseJob.
+func reserveJob() bool {
+	if n := activeJobs.Add(1); maxAsyncJobs > 0 && n > int64(maxAsyncJobs) {
+		activeJobs.Add(-1)
+		return false
+	}
+	return true
+}
+
+func releaseJob() {
+	activeJobs.Add(-1)
+}
+
+func registerJob(id, key string, cancel context.CancelFunc) *asyncJob {
+	job := &asyncJob{key: key, cancel: cancel, status: jobRunning}
+	jobsMu.Lock()
+	jobs[id] = job
+	jobsMu.Unlock()
+	return job
+}
+
+// finish records the outcome of a job unless it was cancelled first, and
+// schedules it to be forgotten. It reports whether the job was cancelled.
+func (j *asyncJob) finish(id string) bool {
+	j.mu.Lock()
+	if j.status == jobRunning {
+		j.status = jobCompleted
+	}
+	cancelled := j.status == jobCancelled
+	j.mu.Unlock()
+	j.cancel()
+
+	time.AfterFunc(jobRetention, func() {
+		jobsMu.Lock()
+		delete(jobs, id)
+		jobsMu.Unlock()
+	})
+	return cancelled
+}
+
+// lookupJob finds the job named by the {id} path value, answering 404 if
+// there is none for the caller's key.
+func lookupJob(w http.ResponseWriter, r *http.Request) (string, *asyncJob) {
+	id := r.PathValue("id")
+	jobsMu.Lock()
+	job := jobs[id]
+	jobsMu.Unlock()
+	if job == nil || job.key != apiKey(r) {
+		respondJSON(w, r, http.StatusNotFound, ErrorResponse{
+			Error: "Job not found",
+		})
+		return id, nil
+	}
+	return id, job
+}
+
+// jobStatusHandler serves GET /query/async/{id}.
+func jobStatusHandler(w http.ResponseWriter, r *http.Request) {
+	id, job := lookupJob(w, r)
+	if job == nil {
+		return
+	}
+	job.mu.Lock()
+	status := job.status
+	job.mu.Unlock()
+	respondJSON(w, r, http.StatusOK, JobResponse{JobID: id, Status: status})
+}
+
+// jobCancelHandler serves POST /query/async/{id}/cancel. Cancelling
+// cancels the job's context, which the drivers turn into aborting the
+// running statement; cancelling a finished job changes nothing.
+func jobCancelHandler(w http.ResponseWriter, r *http.Request) {
+	id, job := lookupJob(w, r)
+	if job == nil {
+		return
+	}
+	job.mu.Lock()
+	if job.status == jobRunning {
+		job.status = jobCancelled
+		job.cancel()
+	}
+	status := job.status
+	job.mu.Unlock()
+	respondJSON(w, r, http.StatusOK, JobResponse{JobID: id, Status: status})
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ---- AFFECTED KEYS ----
+
+var (
+	errNotSingleTable = errors.New("returnKeys needs a single-table UPDATE or DELETE without RETURNING")
+	errNoPrimaryKey   = errors.New("the target table has no primary key")
+)
+
+// dmlTarget is the part of a single-table UPDATE/DELETE needed to select
+// the rows it will touch.
+type dmlTarget struct {
+	table     []token // the table reference as written
+	schema    string  // unquoted, "" when not qualified
+	name      string  // unquoted
+	qualifier string  // alias if any, else the table as written
+	tail      string  // WHERE ... to the end, placeholders renumbered
+	tailArgs  []interface{}
+}
+
+// parseDMLTarget picks apart "UPDATE t [AS a] SET ... [WHERE ...]" and
+// "DELETE FROM t [AS a] [WHERE ...]", rejecting multi-table forms (joins,
+// comma lists, UPDATE ... FROM, DELETE ... USING). The WHERE onwards is
+// kept verbatim, along with the args its placeholders bind.
+func parseDMLTarget(sql string, args []interface{}) (dmlTarget, error) {
+	toks := tokenize(sql)
+	var sig []int // indices of significant tokens
+	for i, t := range toks {
+		if t.kind != tokSpace && t.kind != tokComment {
+			sig = append(sig, i)
+		}
+	}
+	at := func(p int) token {
+		if p < len(sig) {
+			return toks[sig[p]]
+		}
+		return token{}
+	}
+	skip := func(p int, words ...string) int {
+		for {
+			matched := false
+			for _, w := range words {
+				if at(p).is(w) {
+					p++
+					matched = true
+				}
+			}
+			if !matched {
+				return p
+			}
+		}
+	}
+
+	var target dmlTarget
+	p := 0
+	isUpdate := at(0).is("UPDATE")
+	switch {
+	case isUpdate:
+		p = skip(1, "LOW_PRIORITY", "IGNORE", "ONLY")
+	case at(0).is("DELETE"):
+		p = skip(1, "LOW_PRIORITY", "QUICK", "IGNORE")
+		if !at(p).is("FROM") {
+			return target, errNotSingleTable
+		}
+		p = skip(p+1, "ONLY")
+	default:
+		return target, errNotSingleTable
+	}
+
+	// Table reference: ident or schema.ident.
+	start := p
+	if !isIdent(at(p)) {
+		return target, errNotSingleTable
+	}
+	target.name = unquoteIdent(at(p))
+	p++
+	if at(p).text == "." && isIdent(at(p+1)) {
+		target.schema, target.name = target.name, unquoteIdent(at(p+1))
+		p += 2
+	}
+	for q := start; q < p; q++ {
+		target.table = append(target.table, toks[sig[q]])
+	}
+	target.qualifier = tokensText(target.table)
+
+	if at(p).is("AS") {
+		p++
+	}
+	if a := at(p); a.kind == tokQuotedIdent || (a.kind == tokWord && !fromListEnd[strings.ToUpper(a.text)] &&
+		!a.is("ORDER")) {
+		target.qualifier = a.text
+		p++
+	}
+
+	if isUpdate {
+		if !at(p).is("SET") {
+			return target, errNotSingleTable
+		}
+	} else if p < len(sig) && !at(p).is("WHERE") && !at(p).is("ORDER") && !at(p).is("LIMIT") {
+		return target, errNotSingleTable
+	}
+
+	// Find the top-level WHERE (or, for DELETE, ORDER/LIMIT) that starts
+	// the tail, refusing forms that pull in other tables.
+	tailStart := len(sig)
+	depth := 0
+	for q := p; q < len(sig); q++ {
+		t := at(q)
+		switch {
+		case t.text == "(":
+			depth++
+		case t.text == ")":
+			depth--
+		case depth > 0:
+		case t.is("FROM"), t.is("USING"), t.is("JOIN"), t.is("RETURNING"):
+			return target, errNotSingleTable
+		case tailStart == len(sig) && (t.is("WHERE") || t.is("ORDER") || t.is("LIMIT")):
+			tailStart = q
+		}
+	}
+
+	// ? placeholders bind in order, so skip those used before the tail;
+	// $n ones are renumbered from $1 for the SELECT.
+	seq := 0
+	tailFrom := len(toks)
+	if tailStart < len(sig) {
+		tailFrom = sig[tailStart]
+	}
+	for _, t := range toks[:tailFrom] {
+		if t.kind == tokPlaceholder && t.text == "?" {
+			seq++
+		}
+	}
+
+	var b strings.Builder
+	for _, t := range toks[tailFrom:] {
+		if t.kind != tokPlaceholder {
+			b.WriteString(t.text)
+			continue
+		}
+		idx := seq
+		if t.text == "?" {
+			seq++
+		} else {
+			n, _ := strconv.Atoi(t.text[1:])
+			idx = n - 1
+		}
+		if idx < 0 || idx >= len(args) {
+			return target, fmt.Errorf("statement has more placeholders than args")
+		}
+		target.tailArgs = append(target.tailArgs, args[idx])
+		if t.text == "?" {
+			b.WriteString("?")
+		} else {
+			b.WriteString("$" + strconv.Itoa(len(target.tailArgs)))
+		}
+	}
+	target.tail = strings.TrimSpace(b.String())
+	return target, nil
+}
+
+func tokensText(toks []token) string {
+	var b strings.Builder
+	for _, t := range toks {
+		b.WriteString(t.text)
+	}
+	return b.String()
+}
+
+// primaryKeyColumns looks up the primary key of target's table, in key
+// order.
+func primaryKeyColumns(ctx context.Context, q queryer, target dmlTarget) ([]string, error) {
+	var query string
+	var args []interface{}
+	if dbDriver == driverPostgres {
+		query = `SELECT a.attname FROM pg_index i
+			JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+			WHERE i.indrelid = $1::regclass AND i.indisprimary
+			ORDER BY array_position(i.indkey, a.attnum)`
+		args = []interface{}{tokensText(target.table)}
+	} else {
+		query = `SELECT COLUMN_NAME FROM information_schema.KEY_COLUMN_USAGE
+			WHERE CONSTRAINT_NAME = 'PRIMARY' AND TABLE_SCHEMA = COALESCE(?, DATABASE()) AND TABLE_NAME = ?
+			ORDER BY ORDINAL_POSITION`
+		var schema interface{}
+		if target.schema != "" {
+			schema = target.schema
+		}
+		args = []interface{}{schema, target.name}
+	}
+
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cols []string
+	for rows.Next() {
+		var col string
+		if err := rows.Scan(&col); err != nil {
+			return nil, err
+		}
+		cols = append(cols, col)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(cols) == 0 {
+		return nil, errNoPrimaryKey
+	}
+	return cols, nil
+}
+
+// affectedKeys selects, and locks, the primary keys of the rows an
+// UPDATE/DELETE is about to touch. q must be the transaction the
+// statement then runs in, so the answer can't go stale in between.
+func affectedKeys(ctx context.Context, q queryer, sqlQuery string, args []interface{}) ([]map[string]interface{}, error) {
+	target, err := parseDMLTarget(sqlQuery, args)
+	if err != nil {
+		return nil, err
+	}
+	cols, err := primaryKeyColumns(ctx, q, target)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := make([]string, len(cols))
+	for i, c := range cols {
+		selected[i] = target.qualifier + "." + quoteIdent(c)
+	}
+	from := tokensText(target.table)
+	if target.qualifier != from {
+		from += " " + target.qualifier
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s %s FOR UPDATE", strings.Join(selected, ", "), from, target.tail)
+
+	rows, err := q.QueryContext(ctx, query, target.tailArgs...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	colTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	scanner := newRowScanner(colTypes)
+	keys := []map[string]interface{}{}
+	for rows.Next() {
+		row, err := scanner.scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, row)
+	}
+	return keys, rows.Err()
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// ---- LOAD DATA ----
+
+var loadIdentPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_$]{0,63}$`)
+
+// checkLoadDataDSN refuses to enable LOAD_DATA when the DSN sets
+// allowAllFiles: with it, any LOAD DATA LOCAL INFILE that reaches the
+// driver could read arbitrary files off this host. Uploads only need the
+// Reader:: handlers, which the driver always permits.
+func checkLoadDataDSN() {
+	if dbDriver != driverMySQL {
+		log.Fatal("LOAD_DATA requires DB_DRIVER=mysql")
+	}
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		log.Fatal("LOAD_DATA: ", err)
+	}
+	if cfg.AllowAllFiles {
+		log.Fatal("LOAD_DATA cannot be enabled while the DSN sets allowAllFiles=true")
+	}
+}
+
+// loadHandler bulk-loads a CSV request body into
+// /load?table=t[&columns=a,b,c][&header=true] with LOAD DATA LOCAL INFILE,
+// streaming the body to the server through a one-off reader handler. The
+// table and columns must exist and be allowed for the caller's key.
+func loadHandler(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	schema, table, qualified := strings.Cut(params.Get("table"), ".")
+	if !qualified {
+		schema, table = "", schema
+	}
+	var columns []string
+	if list := params.Get("columns"); list != "" {
+		columns = strings.Split(list, ",")
+	}
+	idents := append([]string{table}, columns...)
+	if qualified {
+		idents = append(idents, schema)
+	}
+	for _, ident := range idents {
+		if !loadIdentPattern.MatchString(ident) {
+			respondJSON(w, r, http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid table or column name",
+				Message: fmt.Sprintf("%q is not a plain identifier", ident),
+			})
+			return
+		}
+	}
+	name := strings.ToLower(params.Get("table"))
+	if !tableAllowed(r, name) {
+		respondJSON(w, r, http.StatusForbidden, ErrorResponse{
+			Error:     "Forbidden",
+			Message:   fmt.Sprintf("table %s is not permitted for this key", name),
+			RequestID: requestID(r),
+		})
+		return
+	}
+
+	ctx, span := startDBSpan(r.Context(), "LOAD", "LOAD DATA LOCAL INFILE INTO "+name)
+	defer span.End()
+	r = r.WithContext(ctx)
+
+	conn, ok := acquireConn(w, r, db)
+	if !ok {
+		return
+	}
+	defer conn.Close()
+
+	// Check the mapping against the table before any data is sent.
+	rows, err := conn.QueryContext(ctx, `SELECT COLUMN_NAME FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = COALESCE(?, DATABASE()) AND TABLE_NAME = ?`, nullIfEmpty(schema), table)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+	known := map[string]bool{}
+	for rows.Next() {
+		var col string
+		if err := rows.Scan(&col); err != nil {
+			rows.Close()
+			respondErr(w, r, err)
+			return
+		}
+		known[strings.ToLower(col)] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		respondErr(w, r, err)
+		return
+	}
+	if len(known) == 0 {
+		respondJSON(w, r, http.StatusBadRequest, ErrorResponse{
+			Error:   "Unknown table",
+			Message: fmt.Sprintf("table %s does not exist", name),
+		})
+		return
+	}
+	for _, col := range columns {
+		if !known[strings.ToLower(col)] {
+			respondJSON(w, r, http.StatusBadRequest, ErrorResponse{
+				Error:   "Unknown column",
+				Message: fmt.Sprintf("table %s has no column %s", name, col),
+			})
+			return
+		}
+	}
+
+	// The handler name is random rather than the (client-supplied)
+	// request ID, so no other statement can guess and read this body.
+	handler := "load-" + newRequestID()
+	body := http.MaxBytesReader(w, r.Body, loadDataMaxBytes)
+	mysql.RegisterReaderHandler(handler, func() io.Reader { return body })
+	defer mysql.DeregisterReaderHandler(handler)
+
+	target := quoteIdent(table)
+	if qualified {
+		target = quoteIdent(schema) + "." + target
+	}
+	var stmt strings.Builder
+	fmt.Fprintf(&stmt, "LOAD DATA LOCAL INFILE 'Reader::%s' INTO TABLE %s CHARACTER SET utf8mb4", handler, target)
+	stmt.WriteString(` FIELDS TERMINATED BY ',' OPTIONALLY ENCLOSED BY '"' ESCAPED BY ''`)
+	stmt.WriteString(` LINES TERMINATED BY '\n'`)
+	if params.Get("header") == "true" {
+		stmt.WriteString(" IGNORE 1 LINES")
+	}
+	if len(columns) > 0 {
+		quoted := make([]string, len(columns))
+		for i, col := range columns {
+			quoted[i] = quoteIdent(col)
+		}
+		stmt.WriteString(" (" + strings.Join(quoted, ", ") + ")")
+	}
+
+	res, err := conn.ExecContext(ctx, stmt.String())
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+	affected, _ := res.RowsAffected()
+	response := ExecResponse{Type: "LOAD", AffectedRows: affected}
+
+	// Rows MySQL skipped or coerced are only reported as warnings.
+	if params.Get("warnings") == "true" {
+		warnings, err := fetchWarnings(ctx, conn)
+		if err != nil {
+			respondErr(w, r, err)
+			return
+		}
+		response.Warnings = warnings
+	}
+	respondJSON(w, r, http.StatusOK, response)
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -1,176 +1,1448 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
-	"strings"
+	"os"
+	"os/signal"
+	"reflect"
+	"regexp"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/sony/gobreaker/v2"
 )
 
-// ---- CONFIG ----
-const (
-	addr = ":3000"
-	dsn  = "root:password@tcp(localhost:3306)/test_db"
+var (
+	ddlLimiter      = newRateLimiter(ddlRateLimit)
+	getQueryLimiter = newRateLimiter(getQueryRateLimit)
 )
 
-var db *sql.DB
+// db is the primary pool. readDB, when DB_READ_DSN is set, serves
+// row-returning statements; otherwise it is nil and db serves everything.
+var (
+	db     *sql.DB
+	readDB *sql.DB
+)
 
-// ---- REQUEST / RESPONSE ----
+// ---- REQUEST ----
 
 type QueryRequest struct {
-	SQL string `json:"sql"`
-}
+	SQL              string        `json:"sql"`
+	Args             []interface{} `json:"args,omitempty"`
+	ArgTypes         []string      `json:"argTypes,omitempty"`
+	ConfirmFullTable bool          `json:"confirmFullTable,omitempty"`
+
+	// Stream writes SELECT rows as they are read rather than buffering
+	// the whole result. Memory stays bounded, but the connection is held
+	// for as long as the client takes to read the response.
+	Stream bool `json:"stream,omitempty"`
+
+	// Fields limits a SELECT's output to these result columns.
+	Fields []string `json:"fields,omitempty"`
+
+	// ReturnKeys makes a single-table UPDATE/DELETE report the primary
+	// keys of the rows it touched, selected under lock in the same
+	// transaction just before it runs.
+	ReturnKeys bool `json:"returnKeys,omitempty"`
+
+	// Label attributes the statement to a client feature in logs, metrics
+	// and the audit log. It is sanitised by sanitizeLabel.
+	Label string `json:"label,omitempty"`
 
-type ErrorResponse struct {
-	Error   string `json:"error"`
-	Message string `json:"message,omitempty"`
+	// ReturnDefinition makes a DDL statement that creates or alters a
+	// single table answer with the table's resulting definition.
+	ReturnDefinition bool `json:"returnDefinition,omitempty"`
+
+	// TimeoutMs overrides DB_STATEMENT_TIMEOUT for this statement, up to
+	// DB_STATEMENT_TIMEOUT_MAX.
+	TimeoutMs int `json:"timeoutMs,omitempty"`
+
+	// FreshConnection runs the statement on a newly opened connection that
+	// is closed afterwards instead of going back to the pool, so no session
+	// state from earlier requests can leak in or out. It costs a full
+	// connect (and TLS/auth handshake) per request, so it is meant for
+	// debugging, not regular traffic.
+	FreshConnection bool `json:"freshConnection,omitempty"`
+
+	// Sort orders a SELECT's result in memory before it is sent, for
+	// callers such as named queries that can't change the SQL. It needs
+	// the buffered result, so it doesn't combine with stream, parquet,
+	// ndjson or msgpack.
+	Sort []SortKey `json:"sort,omitempty"`
+
+	// MaxReplicaLag is the replication lag, in seconds, a read tolerates.
+	// Past it, or if the lag can't be determined, the read goes to the
+	// primary; 0 always reads from the primary.
+	MaxReplicaLag *float64 `json:"maxReplicaLag,omitempty"`
+
+	// Charset names the encoding text columns are really stored in, e.g.
+	// latin1 or shift_jis for legacy tables, so their bytes are transcoded
+	// to UTF-8 rather than passed through.
+	Charset string `json:"charset,omitempty"`
+
+	// CallbackURL runs the statement in the background and POSTs the
+	// response there; the request itself only returns a job ID.
+	CallbackURL string `json:"callbackUrl,omitempty"`
 }
 
 // ---- HANDLER ----
 
+// queryFromURL fills req from GET /query?sql=...&arg=...&argType=...
+// (&field=... to project, &label=...), enforcing the limits of the GET form: SELECT
+// only, GET_QUERY_MAX_LENGTH and GET_QUERY_RATE_LIMIT. It reports whether
+// the request may proceed.
+func queryFromURL(w http.ResponseWriter, r *http.Request, req *QueryRequest) bool {
+	if !getQueryLimiter.allow(rateLimitKey(apiKey(r), requestInfoFrom(r).clientIP)) {
+		w.Header().Set("Retry-After", strconv.Itoa(getQueryLimiter.retryAfterSeconds()))
+		respondJSON(w, r, http.StatusTooManyRequests, ErrorResponse{
+			Error: "GET query rate limit exceeded",
+		})
+		return false
+	}
+
+	params := r.URL.Query()
+	req.SQL = params.Get("sql")
+	if len(req.SQL) > getQueryMaxLength {
+		respondJSON(w, r, http.StatusRequestURITooLong, ErrorResponse{
+			Error:   "SQL too long",
+			Message: fmt.Sprintf("GET queries are limited to %d bytes of SQL; use POST", getQueryMaxLength),
+		})
+		return false
+	}
+	if t := statementType(req.SQL); t != "" && t != "SELECT" {
+		respondJSON(w, r, http.StatusBadRequest, ErrorResponse{
+			Error: "Only SELECT statements can be sent with GET",
+		})
+		return false
+	}
+
+	for _, arg := range params["arg"] {
+		req.Args = append(req.Args, arg)
+	}
+	req.ArgTypes = params["argType"]
+	req.Fields = params["field"]
+	req.Label = params.Get("label")
+	return true
+}
+
 func queryHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
+	start := time.Now()
+	var req QueryRequest
+	switch {
+	case r.Method == http.MethodGet && getQueries:
+		if !queryFromURL(w, r, &req) {
+			return
+		}
+	case r.Method == http.MethodPost:
+		if berr := decodeJSONBody(w, r, &req); berr != nil {
+			respondJSON(w, r, berr.status, ErrorResponse{
+				Error: berr.msg,
+			})
+			return
+		}
+	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	var req QueryRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondJSON(w, http.StatusBadRequest, ErrorResponse{
-			Error: "Invalid JSON body",
-		})
+	if req.CallbackURL != "" {
+		startWebhookJob(w, r, req)
 		return
 	}
 
-	sqlQuery := strings.TrimSpace(req.SQL)
+	sqlQuery := trimStatement(req.SQL)
 	if sqlQuery == "" {
-		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+		respondJSON(w, r, http.StatusBadRequest, ErrorResponse{
 			Error: "SQL query is required",
 		})
 		return
 	}
+	if !checkSingleStatement(w, r, sqlQuery, nil) || !checkComplexity(w, r, sqlQuery, nil) {
+		return
+	}
+	sqlQuery, args, err := bindStatement(sqlQuery, req.Args, req.ArgTypes)
+	if err != nil {
+		respondJSON(w, r, http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid args",
+			Message: err.Error(),
+		})
+		return
+	}
 
-	queryType := strings.ToUpper(strings.Fields(sqlQuery)[0])
+	queryType := statementType(sqlQuery)
+	withWarnings := r.URL.Query().Get("warnings") == "true"
 
-	switch queryType {
+	binary := binaryBase64
+	if b := r.URL.Query().Get("binary"); b != "" {
+		if b != binaryBase64 && b != binaryHex {
+			respondJSON(w, r, http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid binary encoding",
+				Message: "binary must be base64 or hex",
+			})
+			return
+		}
+		binary = b
+	}
 
-	case "SELECT":
-		rows, err := db.Query(sqlQuery)
+	charset, err := sourceCharset(req.Charset)
+	if err != nil {
+		respondJSON(w, r, http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid charset",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if format := r.URL.Query().Get("format"); len(req.Sort) > 0 && (queryType != "SELECT" || req.Stream || format == "parquet" || format == "ndjson" || wantsMsgpack(r)) {
+		respondJSON(w, r, http.StatusBadRequest, ErrorResponse{
+			Error:   "Cannot sort",
+			Message: "sort is only available for buffered SELECT results",
+		})
+		return
+	}
+
+	// ?typed=true tags each cell with its SQL type; parquet already
+	// carries types in its schema.
+	typed := r.URL.Query().Get("typed") == "true" && r.URL.Query().Get("format") != "parquet"
+
+	info := requestInfoFrom(r)
+	info.queryType, info.sql, info.args = queryType, sqlQuery, args
+	info.label = sanitizeLabel(req.Label)
+
+	if scope := callerScope(r); !scopeAllows(scope, queryType) {
+		respondJSON(w, r, http.StatusForbidden, ErrorResponse{
+			Error:     "Forbidden",
+			Message:   fmt.Sprintf("%s statements are not permitted with %s scope", queryType, scope),
+			RequestID: requestID(r),
+		})
+		return
+	}
+	if denied := tableRestriction(r, sqlQuery); denied != "" {
+		respondJSON(w, r, http.StatusForbidden, ErrorResponse{
+			Error:     "Forbidden",
+			Message:   fmt.Sprintf("%s is not permitted for this key", denied),
+			RequestID: requestID(r),
+		})
+		return
+	}
+	if !checkQueryWindow(w, r, queryType) {
+		return
+	}
+
+	if !checkRequireWhere(w, r, sqlQuery, req.ConfirmFullTable, nil) {
+		return
+	}
+
+	execSQL := tagQuery(r, sqlQuery)
+
+	ctx, span := startDBSpan(r.Context(), queryType, sqlQuery)
+	defer span.End()
+	r = r.WithContext(ctx)
+
+	pool := poolFor(queryType)
+	if req.MaxReplicaLag != nil && pool == readDB {
+		pool = poolWithinLag(ctx, *req.MaxReplicaLag)
+	}
+	if req.FreshConnection {
+		pool, err = freshPool(pool)
 		if err != nil {
-			respondErr(w, err)
+			respondErr(w, r, err)
 			return
 		}
-		defer rows.Close()
+		defer pool.Close()
+	}
 
-		columns, _ := rows.Columns()
-		results := []map[string]interface{}{}
+	// ?trace=true reports how long each phase took; see QueryTiming.
+	var timing *QueryTiming
+	if r.URL.Query().Get("trace") == "true" {
+		timing = &QueryTiming{}
+	}
+	phase := time.Now()
 
-		for rows.Next() {
-			values := make([]interface{}, len(columns))
-			valuePtrs := make([]interface{}, len(columns))
+	// A dedicated connection keeps session state (e.g. SHOW WARNINGS)
+	// tied to the statement we just ran.
+	conn, ok := acquireConn(w, r, pool)
+	if !ok {
+		return
+	}
+	defer conn.Close()
+	if timing != nil {
+		timing.AcquireMs = milliseconds(time.Since(phase))
+	}
 
-			for i := range values {
-				valuePtrs[i] = &values[i]
+	// QUERY_TIMEOUT starts once a connection is in hand, so time spent
+	// waiting on the pool doesn't count against the statement.
+	if queryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, queryTimeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+
+	// With RLS enabled the statement runs in a transaction carrying the
+	// caller's identity, and returnKeys needs one to lock the rows it
+	// reports; commit is called once the statement succeeded.
+	returnKeys := req.ReturnKeys && (queryType == "UPDATE" || queryType == "DELETE")
+	var q queryer = conn
+	commit := func() error { return nil }
+	if rlsMode != "" || returnKeys {
+		var user string
+		if rlsMode != "" {
+			if user, err = dbIdentityFor(apiKey(r)); err != nil {
+				respondJSON(w, r, http.StatusForbidden, ErrorResponse{
+					Error:   "Forbidden",
+					Message: err.Error(),
+				})
+				return
 			}
+		}
 
-			if err := rows.Scan(valuePtrs...); err != nil {
-				respondErr(w, err)
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			respondErr(w, r, err)
+			return
+		}
+		defer tx.Rollback()
+
+		if user != "" {
+			if err := applyDBIdentity(ctx, tx, user); err != nil {
+				respondErr(w, r, err)
 				return
 			}
+		}
+		q, commit = tx, tx.Commit
+	}
+
+	if key := r.Header.Get("Idempotency-Key"); key != "" && !isReadQuery(queryType) {
+		scope := apiKey(r) + "\x00" + key
+		entry, state := idempotency.begin(scope, fmt.Sprint(sqlQuery, args))
+		switch state {
+		case idempotencyReplay:
+			replayResponse(w, entry)
+			return
+		case idempotencyInProgress:
+			respondJSON(w, r, http.StatusConflict, ErrorResponse{
+				Error: "A request with this Idempotency-Key is still in progress",
+			})
+			return
+		case idempotencyMismatch:
+			respondJSON(w, r, http.StatusUnprocessableEntity, ErrorResponse{
+				Error: "Idempotency-Key was already used for a different request",
+			})
+			return
+		}
+
+		rec := &captureWriter{ResponseWriter: w}
+		w = rec
+		defer idempotency.finish(scope, rec)
+	}
+
+	if len(rewriteRules) > 0 {
+		if rewritten := rewriteQuery(ctx, q, requestID(r), sqlQuery); rewritten != sqlQuery {
+			sqlQuery = rewritten
+			execSQL = tagQuery(r, sqlQuery)
+		}
+	}
 
-			row := map[string]interface{}{}
-			for i, col := range columns {
-				if b, ok := values[i].([]byte); ok {
-					row[col] = string(b)
-				} else {
-					row[col] = values[i]
+	if timeout := statementTimeoutFor(req.TimeoutMs); timeout > 0 {
+		hinted, reset, err := applyStatementTimeout(ctx, q, q != queryer(conn), queryType, execSQL, timeout)
+		if err != nil {
+			respondErr(w, r, err)
+			return
+		}
+		defer reset()
+		execSQL = hinted
+	}
+
+	switch {
+
+	case returnsRows(queryType):
+		var meta *ResponseMeta
+		if costMaxRows > 0 && queryType == "SELECT" {
+			estimate, err := estimateRows(ctx, q, sqlQuery, args)
+			if err != nil {
+				respondErr(w, r, err)
+				return
+			}
+			if estimate > costMaxRows {
+				msg := fmt.Sprintf("estimated %.0f rows examined, limit is %.0f", estimate, costMaxRows)
+				if costPolicy == costReject {
+					respondJSON(w, r, http.StatusBadRequest, ErrorResponse{
+						Error:   "Query too expensive",
+						Message: msg,
+					})
+					return
 				}
+				meta = &ResponseMeta{EstimatedRows: estimate, CostWarning: msg}
 			}
-			results = append(results, row)
 		}
 
-		respondJSON(w, http.StatusOK, map[string]interface{}{
-			"type":  "SELECT",
-			"rows":  results,
-			"count": len(results),
-		})
+		// The plan is taken on the same connection (and transaction) that
+		// runs the query, so both see the same session state.
+		if queryType == "SELECT" && r.URL.Query().Get("withPlan") == "true" {
+			plan, err := explainRows(ctx, q, sqlQuery, args)
+			if err != nil {
+				respondErr(w, r, err)
+				return
+			}
+			if meta == nil {
+				meta = &ResponseMeta{}
+			}
+			meta.Plan = plan
+		}
 
-	case "INSERT", "UPDATE", "DELETE":
-		res, err := db.Exec(sqlQuery)
+		// Examined rows are the Handler_read_* delta around the query, so
+		// they are only available on MySQL.
+		withStats := r.URL.Query().Get("stats") == "true" && dbDriver == driverMySQL
+		var readsBefore int64
+		if withStats {
+			if readsBefore, err = handlerReads(ctx, q); err != nil {
+				respondErr(w, r, err)
+				return
+			}
+		}
+
+		phase = time.Now()
+		rows, err := q.QueryContext(ctx, execSQL, args...)
+		if err != nil {
+			respondErr(w, r, err)
+			return
+		}
+		defer rows.Close()
+		if timing != nil {
+			timing.ExecuteMs = milliseconds(time.Since(phase))
+		}
+		phase = time.Now()
+		var encodeTime time.Duration
+
+		// Metadata is read before the scan loop so that an empty result
+		// still describes its shape.
+		colTypes, err := rows.ColumnTypes()
 		if err != nil {
-			respondErr(w, err)
+			respondErr(w, r, err)
+			return
+		}
+		columns := columnNames(colTypes)
+		if maxColumns > 0 && len(columns) > maxColumns {
+			respondJSON(w, r, http.StatusBadRequest, ErrorResponse{
+				Error:   "Too many columns",
+				Message: fmt.Sprintf("result has %d columns, limit is %d", len(columns), maxColumns),
+			})
+			return
+		}
+		var stages pipeline
+		if nq := namedQueryFrom(r); nq != nil {
+			stages = nq.pipeline
+		}
+		scanner := newRowScanner(colTypes)
+		scanner.binary = binary
+		scanner.typed = typed
+		scanner.charset = charset
+		scanner.pipeline = stages
+		if len(req.Fields) > 0 {
+			if err := scanner.project(req.Fields); err != nil {
+				respondJSON(w, r, http.StatusBadRequest, ErrorResponse{
+					Error:   "Invalid fields",
+					Message: err.Error(),
+				})
+				return
+			}
+		}
+
+		if err := validateSort(req.Sort, scanner.columnInfo()); err != nil {
+			respondJSON(w, r, http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid sort",
+				Message: err.Error(),
+			})
+			return
+		}
+
+		rowLimit := rowLimitFor(apiKey(r))
+		if rowLimit > 0 {
+			w.Header().Set("X-Row-Limit", strconv.Itoa(rowLimit))
+		}
+
+		if r.URL.Query().Get("format") == "parquet" {
+			if stages != nil {
+				respondJSON(w, r, http.StatusBadRequest, ErrorResponse{
+					Error:   "Invalid format",
+					Message: "parquet output is not available for named queries with a result pipeline",
+				})
+				return
+			}
+			n := writeParquet(w, r, rows, scanner, rowLimit)
+			setSpanRowCount(span, "db.rows_returned", int64(n))
+			return
+		}
+		if wantsMsgpack(r) {
+			n := writeMsgpackStream(w, r, rows, scanner, queryType, rowLimit)
+			setSpanRowCount(span, "db.rows_returned", int64(n))
+			return
+		}
+		if r.URL.Query().Get("format") == "ndjson" {
+			n := writeNDJSON(w, r, rows, scanner, rowLimit)
+			setSpanRowCount(span, "db.rows_returned", int64(n))
+			return
+		}
+		if req.Stream {
+			n := writeJSONStream(w, r, rows, scanner, queryType, rowLimit)
+			setSpanRowCount(span, "db.rows_returned", int64(n))
+			return
+		}
+
+		// Procedures and some admin commands return several result sets.
+		// The first fills the flat fields; when there are more, all of
+		// them are listed in resultSets too. fields only projects the first.
+		firstColumns := scanner.columnInfo()
+		var results []map[string]interface{}
+		var truncated, truncatedCells bool
+		var sets []ResultSet
+		total := 0
+		size := &byteCounter{}
+		sizeEnc := json.NewEncoder(size)
+
+		for {
+			set := ResultSet{Columns: scanner.columnInfo(), Rows: []map[string]interface{}{}}
+			for rows.Next() {
+				if rowLimit > 0 && len(set.Rows) >= rowLimit {
+					set.Truncated = true
+					break
+				}
+
+				row, err := scanner.scan(rows)
+				if err != nil {
+					respondErr(w, r, err)
+					return
+				}
+				if maxResponseBytes > 0 || timing != nil {
+					encodeStart := time.Now()
+					_ = sizeEnc.Encode(row)
+					encodeTime += time.Since(encodeStart)
+					if overByteLimit(size.n) {
+						respondJSON(w, r, http.StatusBadRequest, ErrorResponse{
+							Error:   "Response too large",
+							Message: fmt.Sprintf("result exceeds %d bytes after %d rows; narrow the query or use stream", maxResponseBytes, total),
+						})
+						return
+					}
+				}
+				set.Rows = append(set.Rows, row)
+				total++
+			}
+			set.Count = len(set.Rows)
+			set.TruncatedCells = scanner.truncatedCells
+			truncatedCells = truncatedCells || scanner.truncatedCells
+			if results == nil {
+				results, truncated = set.Rows, set.Truncated
+			}
+			// MySQL ends a CALL with a status result that has no columns.
+			if len(sets) == 0 || len(set.Columns) > 0 {
+				sets = append(sets, set)
+			}
+
+			if !rows.NextResultSet() {
+				break
+			}
+			colTypes, err := rows.ColumnTypes()
+			if err != nil {
+				respondErr(w, r, err)
+				return
+			}
+			scanner = newRowScanner(colTypes)
+			scanner.binary = binary
+			scanner.typed = typed
+			scanner.charset = charset
+			scanner.pipeline = stages
+		}
+		if err := rows.Err(); err != nil {
+			respondErr(w, r, err)
+			return
+		}
+		rows.Close()
+		setSpanRowCount(span, "db.rows_returned", int64(total))
+		if timing != nil {
+			timing.ScanMs = milliseconds(time.Since(phase) - encodeTime)
+			timing.EncodeMs = milliseconds(encodeTime)
+		}
+
+		// SHOW WARNINGS goes first: reading the handler counters would
+		// clear them.
+		var warnings []Warning
+		if withWarnings {
+			if warnings, err = fetchWarnings(ctx, q); err != nil {
+				respondErr(w, r, err)
+				return
+			}
+		}
+
+		if withStats {
+			readsAfter, err := handlerReads(ctx, q)
+			if err != nil {
+				respondErr(w, r, err)
+				return
+			}
+			if meta == nil {
+				meta = &ResponseMeta{}
+			}
+			examined := readsAfter - readsBefore
+			meta.RowsExamined = &examined
+		}
+
+		if err := commit(); err != nil {
+			respondErr(w, r, err)
+			return
+		}
+
+		if queryType == "EXPLAIN" && isJSONExplain(sqlQuery) {
+			parseExplainJSON(results)
+		}
+
+		// Sorting only part of a result would misrepresent the rest.
+		if len(req.Sort) > 0 {
+			if truncated {
+				respondJSON(w, r, http.StatusBadRequest, ErrorResponse{
+					Error:   "Cannot sort",
+					Message: fmt.Sprintf("result exceeds the row limit of %d; sort in the query or narrow it", rowLimit),
+				})
+				return
+			}
+			sortRows(results, req.Sort)
+		}
+
+		response := SelectResponse{
+			Type:      queryType,
+			Columns:   firstColumns,
+			Rows:      results,
+			Count:     len(results),
+			Truncated: truncated,
+			Meta:      meta,
+
+			TruncatedCells: truncatedCells,
+		}
+		if len(sets) > 1 {
+			response.ResultSets = sets
+		}
+
+		// ?checksum=true adds a hash of the rows; ?checksum=only sends it
+		// in place of them.
+		if mode := r.URL.Query().Get("checksum"); mode == "true" || mode == "only" {
+			sum, err := resultChecksum(results)
+			if err != nil {
+				respondErr(w, r, err)
+				return
+			}
+			response.Checksum = sum
+			if mode == "only" {
+				response.Rows = []map[string]interface{}{}
+				response.ResultSets = nil
+			}
+		}
+
+		if withWarnings {
+			response.Warnings = warnings
+		}
+
+		if etagEnabled && queryType == "SELECT" {
+			etag, err := resultETag(sqlQuery, args, response)
+			if err != nil {
+				respondErr(w, r, err)
+				return
+			}
+			w.Header().Set("ETag", etag)
+			w.Header().Set("Cache-Control", "private, no-cache")
+			if etagMatches(r, etag) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+
+		if timing != nil {
+			timing.TotalMs = milliseconds(time.Since(start))
+			if response.Meta == nil {
+				response.Meta = &ResponseMeta{}
+			}
+			response.Meta.Timing = timing
+		}
+
+		respondResult(w, r, response)
+
+	case queryType == "INSERT" || queryType == "UPDATE" || queryType == "DELETE":
+		var keys []map[string]interface{}
+		if returnKeys {
+			keys, err = affectedKeys(ctx, q, sqlQuery, args)
+			if errors.Is(err, errNotSingleTable) || errors.Is(err, errNoPrimaryKey) {
+				respondJSON(w, r, http.StatusBadRequest, ErrorResponse{
+					Error:   "Cannot return affected keys",
+					Message: err.Error(),
+				})
+				return
+			}
+			if err != nil {
+				respondErr(w, r, err)
+				return
+			}
+		}
+
+		phase = time.Now()
+		res, err := q.ExecContext(ctx, execSQL, args...)
+		if err != nil {
+			respondErr(w, r, err)
+			return
+		}
+		if timing != nil {
+			timing.ExecuteMs = milliseconds(time.Since(phase))
+		}
+		// Read before COMMIT, which would clear them.
+		var warnings []Warning
+		if withWarnings {
+			if warnings, err = fetchWarnings(ctx, q); err != nil {
+				respondErr(w, r, err)
+				return
+			}
+		}
+		if err := commit(); err != nil {
+			respondErr(w, r, err)
 			return
 		}
 
 		affected, _ := res.RowsAffected()
 		insertID, _ := res.LastInsertId()
+		setSpanRowCount(span, "db.rows_affected", affected)
 
-		response := map[string]interface{}{
-			"type":         queryType,
-			"affectedRows": affected,
+		response := ExecResponse{
+			Type:         queryType,
+			AffectedRows: affected,
+			AffectedKeys: keys,
 		}
 
 		if queryType == "INSERT" {
-			response["insertId"] = insertID
+			response.InsertID = &insertID
+		}
+
+		response.Warnings = warnings
+		if timing != nil {
+			timing.TotalMs = milliseconds(time.Since(start))
+			response.Meta = &ResponseMeta{Timing: timing}
 		}
 
-		respondJSON(w, http.StatusOK, response)
+		respondResult(w, r, response)
 
 	default:
 		// CREATE / ALTER / DROP / TRUNCATE / etc.
-		if _, err := db.Exec(sqlQuery); err != nil {
-			respondErr(w, err)
+		if !checkDDL(w, r, queryType, nil) {
 			return
 		}
 
-		respondJSON(w, http.StatusOK, map[string]interface{}{
-			"type":   queryType,
-			"status": "executed",
-		})
+		phase = time.Now()
+		if _, err := q.ExecContext(ctx, execSQL, args...); err != nil {
+			respondErr(w, r, err)
+			return
+		}
+		if timing != nil {
+			timing.ExecuteMs = milliseconds(time.Since(phase))
+		}
+		// Read before COMMIT and the definition lookup, which would clear
+		// them.
+		var warnings []Warning
+		if withWarnings {
+			var err error
+			if warnings, err = fetchWarnings(ctx, q); err != nil {
+				respondErr(w, r, err)
+				return
+			}
+		}
+		if err := commit(); err != nil {
+			respondErr(w, r, err)
+			return
+		}
+
+		response := DDLResponse{
+			Type:   queryType,
+			Status: "executed",
+		}
+
+		// The statement has already taken effect, so failing to read the
+		// definition back is logged rather than reported as an error.
+		if table := ddlTarget(sqlQuery); req.ReturnDefinition && table != nil {
+			definition, err := tableDefinition(ctx, conn, table)
+			if err != nil {
+				log.Printf("[%s] reading definition of %s: %v", requestID(r), tokensText(table), err)
+			}
+			response.Definition = definition
+		}
+
+		response.Warnings = warnings
+		if timing != nil {
+			timing.TotalMs = milliseconds(time.Since(start))
+			response.Meta = &ResponseMeta{Timing: timing}
+		}
+
+		respondResult(w, r, response)
 	}
 }
 
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	status := "ok"
+	if dbBreaker != nil && dbBreaker.State() != gobreaker.StateClosed {
+		status = "degraded"
+	}
+	pools := map[string]PoolStats{"primary": poolStats(db)}
+	if readDB != nil {
+		pools["read"] = poolStats(readDB)
+	}
+	resp := HealthResponse{
+		Status:      status,
+		Breaker:     breakerState(),
+		Pools:       pools,
+		Concurrency: admit.stats(),
+
+		OpenTransactions: openTransactions.Load(),
+	}
+	if lag, ok := replicaLag(r.Context()); ok {
+		resp.ReplicaLagSeconds = &lag
+	}
+	respondJSON(w, r, http.StatusOK, resp)
+}
+
 // ---- HELPERS ----
 
-func respondErr(w http.ResponseWriter, err error) {
-	log.Println(err)
-	respondJSON(w, http.StatusInternalServerError, ErrorResponse{
-		Error:   "Query execution failed",
-		Message: err.Error(),
+const (
+	poolMaxOpen = 10
+	poolMaxIdle = 5
+)
+
+func openDB(dsn string) (*sql.DB, error) {
+	pool, err := sql.Open(sqlDriverName(dbDriver), dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	pool.SetMaxOpenConns(poolMaxOpen)
+	pool.SetMaxIdleConns(poolMaxIdle)
+	pool.SetConnMaxIdleTime(connMaxIdleTime)
+
+	if err := pool.Ping(); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return pool, nil
+}
+
+// warmUp runs WARMUP_QUERY on poolMaxIdle connections at once, so they are
+// open and idle (and server-side caches primed) before the first request.
+// Failures are logged, never fatal.
+func warmUp(name string, pool *sql.DB) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	conns := make([]*sql.Conn, 0, poolMaxIdle)
+	defer func() {
+		for _, c := range conns {
+			c.Close()
+		}
+	}()
+
+	for range poolMaxIdle {
+		conn, err := pool.Conn(ctx)
+		if err != nil {
+			log.Printf("warmup of %s pool failed: %v", name, err)
+			return
+		}
+		conns = append(conns, conn)
+		if _, err := conn.ExecContext(ctx, warmupQuery); err != nil {
+			log.Printf("warmup of %s pool failed: %v", name, err)
+			return
+		}
+	}
+	log.Printf("warmed up %d %s connections in %s", len(conns), name, time.Since(start).Round(time.Millisecond))
+}
+
+// validateIdleConns pings the connections pool has idle every
+// CONN_VALIDATE_INTERVAL, so ones the server or a failover dropped are
+// evicted before a request draws them. database/sql discards a connection
+// whose ping reports it broken.
+func validateIdleConns(name string, pool *sql.DB) {
+	for range time.Tick(connValidateInterval) {
+		ctx, cancel := context.WithTimeout(context.Background(), connValidateInterval)
+		idle := pool.Stats().Idle
+		conns := make([]*sql.Conn, 0, idle)
+		evicted := 0
+		for range idle {
+			conn, err := pool.Conn(ctx)
+			if err != nil {
+				break
+			}
+			conns = append(conns, conn)
+			if err := conn.PingContext(ctx); err != nil {
+				evicted++
+				debugf("%s pool: evicting connection: %v", name, err)
+			}
+		}
+		for _, c := range conns {
+			c.Close()
+		}
+		cancel()
+		if evicted > 0 {
+			log.Printf("%s pool: evicted %d of %d idle connections that failed a ping", name, evicted, len(conns))
+		}
+	}
+}
+
+// acquireConn takes a dedicated connection from pool, waiting at most
+// CONN_ACQUIRE_TIMEOUT. Running out of that wait answers 503 so pool
+// exhaustion is distinguishable from slow statements.
+func acquireConn(w http.ResponseWriter, r *http.Request, pool *sql.DB) (*sql.Conn, bool) {
+	ctx := r.Context()
+	if connAcquireTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, connAcquireTimeout)
+		defer cancel()
+	}
+
+	conn, err := pool.Conn(ctx)
+	if err == nil {
+		return conn, true
+	}
+	if r.Context().Err() == nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		log.Printf("[%s] no connection available after %s", requestID(r), connAcquireTimeout)
+		w.Header().Set("Retry-After", "1")
+		respondJSON(w, r, http.StatusServiceUnavailable, ErrorResponse{
+			Error:     "No connection available",
+			Message:   fmt.Sprintf("no database connection became free within %s", connAcquireTimeout),
+			RequestID: requestID(r),
+		})
+		return nil, false
+	}
+	respondErr(w, r, err)
+	return nil, false
+}
+
+// poolFor routes row-returning statements to the read pool when one is
+// configured and everything else to the primary.
+func poolFor(queryType string) *sql.DB {
+	if readDB != nil && isReadQuery(queryType) {
+		return readDB
+	}
+	return db
+}
+
+// freshPool opens a single-use, non-pooling stand-in for pool, for
+// freshConnection: its one connection is opened on demand and closed,
+// not kept idle, when released.
+func freshPool(pool *sql.DB) (*sql.DB, error) {
+	target := dsn
+	if pool == readDB {
+		target = readDSN
+	}
+	fresh, err := sql.Open(sqlDriverName(dbDriver), target)
+	if err != nil {
+		return nil, err
+	}
+	fresh.SetMaxOpenConns(1)
+	fresh.SetMaxIdleConns(0)
+	return fresh, nil
+}
+
+func poolStats(pool *sql.DB) PoolStats {
+	st := pool.Stats()
+	return PoolStats{
+		OpenConnections: st.OpenConnections,
+		InUse:           st.InUse,
+		Idle:            st.Idle,
+		WaitCount:       st.WaitCount,
+	}
+}
+
+// tagQuery prepends the request ID as a SQL comment when TAG_QUERIES is on.
+// Routing decisions are made on the untagged statement, and request IDs
+// are restricted to requestIDPattern, which can't close the comment.
+func tagQuery(r *http.Request, sqlQuery string) string {
+	id := requestID(r)
+	if !tagQueries || !requestIDPattern.MatchString(id) {
+		return sqlQuery
+	}
+	return "/* req=" + id + " */ " + sqlQuery
+}
+
+// returnsRows reports whether a statement's results are read as rows: the
+// read statements plus CALL, whose procedures may return result sets but
+// otherwise count as writes.
+func returnsRows(queryType string) bool {
+	return isReadQuery(queryType) || queryType == "CALL"
+}
+
+// isReadQuery reports whether a statement returns rows rather than
+// modifying data or schema.
+func isReadQuery(queryType string) bool {
+	switch queryType {
+	case "SELECT", "SHOW", "EXPLAIN", "DESCRIBE", "DESC":
+		return true
+	}
+	return false
+}
+
+// fetchWarnings runs SHOW WARNINGS on q. It must be called on the same
+// connection that executed the statement, before anything else runs there:
+// every statement resets the warnings MySQL keeps.
+func fetchWarnings(ctx context.Context, q queryer) ([]Warning, error) {
+	if dbDriver != driverMySQL {
+		// Postgres reports notices asynchronously; there is no SHOW WARNINGS.
+		return []Warning{}, nil
+	}
+
+	rows, err := q.QueryContext(ctx, "SHOW WARNINGS")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	warnings := []Warning{}
+	for rows.Next() {
+		var wr Warning
+		if err := rows.Scan(&wr.Level, &wr.Code, &wr.Message); err != nil {
+			return nil, err
+		}
+		warnings = append(warnings, wr)
+	}
+	return warnings, rows.Err()
+}
+
+// respondErr reports a failed statement. A cancelled request context means
+// the client went away, so nothing is sent and the error is only logged
+// with DEBUG; a deadline, ours or the database's, becomes 504 rather than
+// 500.
+func respondErr(w http.ResponseWriter, r *http.Request, err error) {
+	info := requestInfoFrom(r)
+	info.err = err
+	id := info.id
+
+	ctxErr := r.Context().Err()
+	if errors.Is(ctxErr, context.Canceled) {
+		debugf("[%s] %s: client went away: %v", id, info.clientIP, err)
+		return
+	}
+
+	var limit *resultLimitError
+	if errors.As(err, &limit) {
+		respondJSON(w, r, http.StatusBadRequest, ErrorResponse{
+			Error:     limit.title,
+			Message:   err.Error(),
+			RequestID: id,
+		})
+		return
+	}
+
+	if info.label != "" {
+		log.Printf("[%s] %s label=%s: %v", id, info.clientIP, info.label, err)
+	} else {
+		log.Printf("[%s] %s: %v", id, info.clientIP, err)
+	}
+	recordSpanError(r.Context(), err)
+
+	if errors.Is(ctxErr, context.DeadlineExceeded) || errors.Is(err, context.DeadlineExceeded) || isStatementTimeout(err) {
+		respondJSON(w, r, http.StatusGatewayTimeout, ErrorResponse{
+			Error:     "Query timed out",
+			Message:   "the statement did not finish within the time limit",
+			RequestID: id,
+			Code:      codeStatementTimeout,
+		})
+		return
+	}
+
+	resp := ErrorResponse{
+		Error:     "Query execution failed",
+		RequestID: id,
+		Code:      errorCode(err),
+	}
+	if !hideErrorDetails {
+		resp.Message = err.Error()
+	}
+	respondJSON(w, r, http.StatusInternalServerError, resp)
+}
+
+// checkSingleStatement answers 400 and returns false when sqlQuery holds
+// more than one statement. index, when set, is echoed in the error body.
+func checkSingleStatement(w http.ResponseWriter, r *http.Request, sqlQuery string, index *int) bool {
+	if !hasMultipleStatements(sqlQuery) {
+		return true
+	}
+	respondJSON(w, r, http.StatusBadRequest, ErrorResponse{
+		Error:     "Multiple statements are not supported",
+		Message:   "send one statement per request, or use /transaction",
+		Statement: index,
 	})
+	return false
+}
+
+// isDDL reports whether queryType is handled as DDL: anything that isn't
+// a read or INSERT/UPDATE/DELETE.
+func isDDL(queryType string) bool {
+	switch queryType {
+	case "INSERT", "UPDATE", "DELETE":
+		return false
+	}
+	return !isReadQuery(queryType)
+}
+
+// checkDDL enforces X-Confirm-DDL and the DDL rate limit for a DDL
+// statement, answering the request and returning false when it may not
+// run. Other statements pass. index, when set, is echoed in the error
+// body.
+func checkDDL(w http.ResponseWriter, r *http.Request, queryType string, index *int) bool {
+	if !isDDL(queryType) {
+		return true
+	}
+	if ddlRequireConfirm && r.Header.Get("X-Confirm-DDL") != "true" {
+		respondJSON(w, r, http.StatusPreconditionRequired, ErrorResponse{
+			Error:     "DDL requires confirmation",
+			Message:   "send X-Confirm-DDL: true to run " + queryType,
+			Statement: index,
+		})
+		return false
+	}
+	if !ddlLimiter.allow(rateLimitKey(apiKey(r), requestInfoFrom(r).clientIP)) {
+		w.Header().Set("Retry-After", strconv.Itoa(ddlLimiter.retryAfterSeconds()))
+		respondJSON(w, r, http.StatusTooManyRequests, ErrorResponse{
+			Error:     "DDL rate limit exceeded",
+			Statement: index,
+		})
+		return false
+	}
+	return true
 }
 
-func respondJSON(w http.ResponseWriter, status int, payload interface{}) {
-	w.Header().Set("Content-Type", "application/json")
+// checkRequireWhere enforces REQUIRE_WHERE, answering the request and
+// returning false for an UPDATE or DELETE without a WHERE clause unless
+// confirmed says every row is meant. index, when set, is echoed in the
+// error body.
+func checkRequireWhere(w http.ResponseWriter, r *http.Request, sqlQuery string, confirmed bool, index *int) bool {
+	if !requireWhere || confirmed {
+		return true
+	}
+	verb := unguardedWrite(sqlQuery)
+	if verb == "" {
+		return true
+	}
+	respondJSON(w, r, http.StatusBadRequest, ErrorResponse{
+		Error:     verb + " without a WHERE clause",
+		Message:   "set confirmFullTable to true to affect every row",
+		Statement: index,
+	})
+	return false
+}
+
+// debugf logs only when DEBUG is set.
+func debugf(format string, args ...interface{}) {
+	if debugLogging {
+		log.Printf(format, args...)
+	}
+}
+
+func respondJSON(w http.ResponseWriter, r *http.Request, status int, payload interface{}) {
+	callback, jsonp := jsonpCallback(r)
+	if jsonp && !jsonpCallbackPattern.MatchString(callback) {
+		callback, jsonp = "", false
+		status = http.StatusBadRequest
+		payload = ErrorResponse{Error: "Invalid JSONP callback name"}
+	}
+
+	contentType := "application/json"
+	if e, ok := payload.(ErrorResponse); ok && errorFormat == errorFormatProblem {
+		payload = problemFrom(e, status, requestID(r))
+		contentType = "application/problem+json"
+	}
+	if responseNaming != namingCamel {
+		payload = applyNaming(reflect.ValueOf(payload))
+	}
+
+	if jsonp {
+		contentType = "application/javascript"
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+	}
+
+	w.Header().Set("Content-Type", contentType)
 	w.WriteHeader(status)
-	_ = json.NewEncoder(w).Encode(payload)
+
+	// The leading comment keeps the response from being read as anything
+	// but script (the Rosetta Flash attack relied on a bare callback).
+	if jsonp {
+		fmt.Fprintf(w, "/**/%s(", callback)
+	}
+	enc := json.NewEncoder(w)
+	if wantsPretty(r) {
+		enc.SetIndent("", "  ")
+	}
+	_ = enc.Encode(payload)
+	if jsonp {
+		fmt.Fprint(w, ");\n")
+	}
+}
+
+// jsonpCallbackPattern allows dotted JavaScript identifiers only, so the
+// callback can't inject script of its own.
+var jsonpCallbackPattern = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]{0,63}(\.[A-Za-z_$][A-Za-z0-9_$]{0,63}){0,3}$`)
+
+// jsonpCallback returns the ?callback= of a GET request when JSONP is on.
+// JSONP lets any page read the response, so it is off unless enabled.
+func jsonpCallback(r *http.Request) (string, bool) {
+	if !jsonpEnabled || r.Method != http.MethodGet || !r.URL.Query().Has("callback") {
+		return "", false
+	}
+	return r.URL.Query().Get("callback"), true
+}
+
+// wantsPretty reports whether the client asked for indented JSON via
+// ?pretty=true or an X-Pretty: true header.
+func wantsPretty(r *http.Request) bool {
+	return r.URL.Query().Get("pretty") == "true" || r.Header.Get("X-Pretty") == "true"
+}
+
+// ---- MIDDLEWARE ----
+
+type ctxKey int
+
+const requestInfoKey ctxKey = iota
+
+// requestInfo is per-request state shared between middleware and handlers.
+type requestInfo struct {
+	id       string
+	clientIP string
+	err      error // last error passed to respondErr
+
+	// queryType, sql and args describe the statement being run, once
+	// parsed.
+	queryType string
+	sql       string
+	args      []interface{}
+	label     string // client-supplied, sanitised
+}
+
+// Client-supplied IDs are only honoured when they are short and made of
+// safe characters; anything else is replaced with a generated one.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDPattern.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		info := &requestInfo{id: id, clientIP: clientIP(r)}
+		ctx := context.WithValue(r.Context(), requestInfoKey, info)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// inFlight counts requests currently being served, for shutdown logging.
+var inFlight atomic.Int64
+
+func trackInFlight(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inFlight.Add(1)
+		defer inFlight.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// statusRecorder captures the status code written by downstream handlers.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestInfoFrom returns the request's shared state. Requests that didn't
+// pass through withRequestID get a throwaway value.
+func requestInfoFrom(r *http.Request) *requestInfo {
+	if info, ok := r.Context().Value(requestInfoKey).(*requestInfo); ok {
+		return info
+	}
+	return &requestInfo{}
+}
+
+func requestID(r *http.Request) string {
+	return requestInfoFrom(r).id
 }
 
 // ---- MAIN ----
 
 func main() {
-	var err error
-	db, err = sql.Open("mysql", dsn)
+	cliQuery := flag.String("query", "", `run this statement ("-" reads stdin), print the result and exit instead of serving`)
+	cliFormat := flag.String("format", "json", "result format with -query: json, ndjson, csv, html, parquet or msgpack")
+	flag.Parse()
+
+	shutdownTracing, err := setupTracing(context.Background())
 	if err != nil {
-		log.Fatal(err)
+		log.Fatal("tracing setup failed:", err)
 	}
 
-	db.SetMaxOpenConns(10)
-	db.SetMaxIdleConns(5)
+	if rlsMode != "" && dbDriver != driverPostgres {
+		log.Fatal("RLS_MODE requires DB_DRIVER=postgres")
+	}
+	if loadDataEnabled {
+		checkLoadDataDSN()
+	}
 
-	if err = db.Ping(); err != nil {
+	db, err = openDB(dsn)
+	if err != nil {
 		log.Fatal("DB connection failed:", err)
 	}
 
-	http.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
-		respondJSON(w, http.StatusOK, map[string]string{"status": "ok"})
-	})
+	if readDSN != "" {
+		readDB, err = openDB(readDSN)
+		if err != nil {
+			log.Fatal("read DB connection failed:", err)
+		}
+	}
 
-	http.HandleFunc("/query", queryHandler)
+	if *cliQuery != "" {
+		code := runCLI(*cliQuery, *cliFormat)
+		_ = db.Close()
+		if readDB != nil {
+			_ = readDB.Close()
+		}
+		_ = shutdownTracing(context.Background())
+		os.Exit(code)
+	}
 
-	log.Println("🚀 Server running on", addr)
-	log.Fatal(http.ListenAndServe(addr, nil))
+	if connValidateInterval > 0 {
+		go validateIdleConns("primary", db)
+		if readDB != nil {
+			go validateIdleConns("read", readDB)
+		}
+	}
+
+	if warmupQuery != "" {
+		warmUp("primary", db)
+		if readDB != nil {
+			warmUp("read", readDB)
+		}
+	}
+
+	if schemaValidation {
+		if err := refreshSchema(); err != nil {
+			log.Fatal("schema snapshot failed: ", err)
+		}
+		if schemaRefreshInterval > 0 {
+			go refreshSchemaPeriodically()
+		}
+	}
+
+	if runMigrationsOnBoot {
+		if err := runMigrations(context.Background(), db, migrationsDir); err != nil {
+			log.Fatal("migrations failed: ", err)
+		}
+	}
+
+	handle("/{$}", []string{"GET"}, "Service status", rootHandler)
+	handle("/health", []string{"GET"}, "Health and circuit breaker state", healthHandler)
+	handle("/info", []string{"GET"}, "Database server version, database, time and SQL mode", requireAPIKey(withBreaker(infoHandler)))
+	handle("/metrics", []string{"GET"}, "Prometheus metrics", metricsHandler)
+	queryMethods := []string{"POST"}
+	if getQueries {
+		queryMethods = []string{"GET", "POST"}
+	}
+	handle("/query", queryMethods, "Run a SQL statement", requireAPIKey(observeQuery(withAdmission(withBreaker(queryHandler)))))
+	handle("/query/async/{id}", []string{"GET"}, "Status of a callback job", requireAPIKey(jobStatusHandler))
+	handle("/query/async/{id}/cancel", []string{"POST"}, "Cancel a callback job", requireAPIKey(jobCancelHandler))
+	handle("/queries", []string{"GET"}, "List named queries; ?format=openapi describes them as OpenAPI", requireAPIKey(namedQueriesHandler))
+	handle("/queries/{name}", []string{"POST"}, "Run a named query from NAMED_QUERIES_FILE", requireAPIKey(observeQuery(withAdmission(withBreaker(namedQueryHandler)))))
+	handle("/explain-cost", []string{"POST"}, "Planner cost of a SELECT", requireAPIKey(withAdmission(withBreaker(explainCostHandler))))
+	handle("/params", []string{"POST"}, "Placeholder count and types of a statement, without running it", requireAPIKey(withBreaker(paramsHandler)))
+	if schemaValidation {
+		handle("/validate", []string{"POST"}, "Check a statement's tables and columns against the schema snapshot, without running it", requireAPIKey(validateHandler))
+	}
+	handle("/diff", []string{"POST"}, "Row-level differences between two SELECTs", requireAPIKey(withAdmission(withBreaker(diffHandler))))
+	handle("/transaction", []string{"POST"}, "Run several statements in one transaction", requireAPIKey(withAdmission(withBreaker(transactionHandler))))
+	handle("/session/begin", []string{"POST"}, "Begin an interactive transaction", requireAPIKey(withBreaker(beginSession)))
+	handle("/session/{id}/{action}", []string{"POST"}, "Run a statement in a session (query), or end it (commit, rollback)", requireAPIKey(withBreaker(sessionHandler)))
+	if loadDataEnabled {
+		handle("/load", []string{"POST"}, "Bulk-load a CSV body into ?table= (write)", requireAPIKey(requireScope(scopeWrite, withAdmission(withBreaker(loadHandler)))))
+	}
+	handle("/cursor/open", []string{"POST"}, "Open a cursor over a result", requireAPIKey(withBreaker(openCursor)))
+	handle("/cursor/{id}/{action}", []string{"POST"}, "Read a cursor's next page, or close it", requireAPIKey(withBreaker(cursorHandler)))
+	handle("/admin/pool", []string{"GET"}, "Detailed connection pool statistics (admin)", requireAPIKey(requireScope(scopeAdmin, poolHandler)))
+	handle("/admin/test-connection", []string{"POST"}, "Ping a candidate DSN (admin)", requireAPIKey(requireScope(scopeAdmin, testConnectionHandler)))
+	handle("/export", []string{"POST"}, "Stream a SELECT as CSV", requireAPIKey(withAdmission(withBreaker(exportHandler))))
+	handle("/subscribe/{channel}", []string{"GET"}, "Stream Postgres notifications on a channel", requireAPIKey(subscribeHandler))
+
+	// Cancelling baseCtx aborts every in-flight request's DB work when the
+	// drain timeout forces the server closed.
+	baseCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           withTracing(withRequestID(trackInFlight(withBodyLogging(http.DefaultServeMux)))),
+		BaseContext:       func(net.Listener) context.Context { return baseCtx },
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+
+	go func() {
+		log.Println("🚀 Server running on", addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+
+	log.Printf("shutting down, draining for up to %s", shutdownTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("drain timeout elapsed with %d requests in flight, forcing close", inFlight.Load())
+		cancelRequests()
+		_ = srv.Close()
+	}
+
+	closeSessions()
+	closeCursors()
+	_ = db.Close()
+	if readDB != nil {
+		_ = readDB.Close()
+	}
+	_ = shutdownTracing(context.Background())
 }
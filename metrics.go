@@ -0,0 +1,140 @@
+package main
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// ---- METRICS ----
+
+var metricsRegistry = prometheus.NewRegistry()
+
+var queueRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "sqlrunner_queue_rejections_total",
+	Help: "Requests rejected with 503 by the concurrency queue, by reason.",
+}, []string{"reason"})
+
+var queriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "sqlrunner_queries_total",
+	Help: "Statements handled, by type, query fingerprint, client label and HTTP status.",
+}, []string{"type", "fingerprint", "label", "status"})
+
+var queryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "sqlrunner_query_duration_seconds",
+	Help:    "Time to answer a statement, including queueing, by type, query fingerprint and client label.",
+	Buckets: prometheus.DefBuckets,
+}, []string{"type", "fingerprint", "label"})
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		queueRejections,
+		queriesTotal,
+		queryDuration,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "sqlrunner_requests_in_flight",
+			Help: "HTTP requests currently being served.",
+		}, func() float64 { return float64(inFlight.Load()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "sqlrunner_queries_active",
+			Help: "DB-bound requests holding a concurrency slot.",
+		}, func() float64 {
+			if s := admit.stats(); s != nil {
+				return float64(s.Active)
+			}
+			return 0
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "sqlrunner_queue_depth",
+			Help: "DB-bound requests waiting for a concurrency slot.",
+		}, func() float64 {
+			if s := admit.stats(); s != nil {
+				return float64(s.Queued)
+			}
+			return 0
+		}),
+	)
+}
+
+var metricsHandler = promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}).ServeHTTP
+
+// maxLabelLength caps a client-supplied query label.
+const maxLabelLength = 64
+
+// sanitizeLabel keeps a query label to [A-Za-z0-9._:-], replacing anything
+// else with '_', and to maxLabelLength bytes.
+func sanitizeLabel(label string) string {
+	label = strings.Map(func(c rune) rune {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9',
+			c == '.', c == '_', c == ':', c == '-':
+			return c
+		}
+		return '_'
+	}, label)
+	if len(label) > maxLabelLength {
+		label = label[:maxLabelLength]
+	}
+	return label
+}
+
+// boundedValues admits client-driven metric label values. Once limit
+// distinct ones have been seen, new ones are counted as "other" so
+// clients can't blow up the series count.
+type boundedValues struct {
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+func (b *boundedValues) admit(v string, limit int) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.seen[v] {
+		return v
+	}
+	if len(b.seen) >= limit {
+		return "other"
+	}
+	if b.seen == nil {
+		b.seen = map[string]bool{}
+	}
+	b.seen[v] = true
+	return v
+}
+
+// seenLabels and seenFingerprints hold the query labels and fingerprints
+// admitted as metric label values, up to QUERY_LABEL_LIMIT and
+// QUERY_FINGERPRINT_LIMIT.
+var seenLabels, seenFingerprints boundedValues
+
+func metricLabel(label string) string {
+	if label == "" {
+		return ""
+	}
+	return seenLabels.admit(label, queryLabelLimit)
+}
+
+func metricFingerprint(fingerprint string) string {
+	return seenFingerprints.admit(fingerprint, queryFingerprintLimit)
+}
+
+// metricTypes are the statement types reported as themselves in the type
+// label; any other first keyword is reported as "OTHER".
+var metricTypes = map[string]bool{
+	"SELECT": true, "WITH": true, "INSERT": true, "REPLACE": true, "UPDATE": true,
+	"DELETE": true, "SHOW": true, "EXPLAIN": true, "DESCRIBE": true, "DESC": true,
+	"CALL": true, "CREATE": true, "ALTER": true, "DROP": true, "TRUNCATE": true,
+	"RENAME": true, "SET": true, "TABLE": true, "VALUES": true,
+}
+
+func metricType(queryType string) string {
+	if metricTypes[queryType] {
+		return queryType
+	}
+	return "OTHER"
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMetricFingerprintLimit(t *testing.T) {
+	saved := queryFingerprintLimit
+	defer func() { queryFingerprintLimit, seenFingerprints = saved, boundedValues{} }()
+	queryFingerprintLimit, seenFingerprints = 3, boundedValues{}
+
+	for i := range 3 {
+		if got := metricFingerprint(fmt.Sprint(i)); got != fmt.Sprint(i) {
+			t.Errorf("fingerprint %d = %q, want it admitted", i, got)
+		}
+	}
+	if got := metricFingerprint("3"); got != "other" {
+		t.Errorf("fingerprint past the limit = %q, want \"other\"", got)
+	}
+	if got := metricFingerprint("1"); got != "1" {
+		t.Errorf("admitted fingerprint = %q, want \"1\"", got)
+	}
+}
+
+func TestMetricLabelLimit(t *testing.T) {
+	saved := queryLabelLimit
+	defer func() { queryLabelLimit, seenLabels = saved, boundedValues{} }()
+	queryLabelLimit, seenLabels = 1, boundedValues{}
+
+	if got := metricLabel(""); got != "" {
+		t.Errorf("empty label = %q, want \"\"", got)
+	}
+	if got := metricLabel("a"); got != "a" {
+		t.Errorf("first label = %q, want \"a\"", got)
+	}
+	if got := metricLabel("b"); got != "other" {
+		t.Errorf("label past the limit = %q, want \"other\"", got)
+	}
+}
+
+func TestMetricType(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"SELECT 1", "SELECT"},
+		{"SELECT/*1*/ 1", "SELECT"},
+		{"/*2*/delete FROM t WHERE 1", "DELETE"},
+		{"FROBNICATE", "OTHER"},
+		{"SELECT/*1*/", "SELECT"},
+	}
+	for _, tt := range tests {
+		if got := metricType(statementType(tt.sql)); got != tt.want {
+			t.Errorf("metricType(statementType(%q)) = %q, want %q", tt.sql, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ---- MIGRATIONS ----
+
+const createMigrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version VARCHAR(255) NOT NULL PRIMARY KEY,
+	applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`
+
+// runMigrations applies every *.sql file in dir, in lexical order, that is
+// not yet recorded in schema_migrations. Each file runs in its own
+// transaction. Note that MySQL commits DDL implicitly, and a file holding
+// several statements needs multiStatements=true in the MySQL DSN.
+func runMigrations(ctx context.Context, db *sql.DB, dir string) error {
+	if _, err := db.ExecContext(ctx, createMigrationsTable); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+
+	applied, err := appliedMigrations(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".sql") || applied[name] {
+			continue
+		}
+
+		body, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		if err := applyMigration(ctx, db, name, string(body)); err != nil {
+			return fmt.Errorf("migration %s: %w", name, err)
+		}
+		log.Println("applied migration", name)
+	}
+	return nil
+}
+
+func appliedMigrations(ctx context.Context, db *sql.DB) (map[string]bool, error) {
+	rows, err := db.QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[string]bool{}
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+func applyMigration(ctx context.Context, db *sql.DB, version, body string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, body); err != nil {
+		return err
+	}
+
+	insert := "INSERT INTO schema_migrations (version) VALUES (?)"
+	if dbDriver == driverPostgres {
+		insert = "INSERT INTO schema_migrations (version) VALUES ($1)"
+	}
+	if _, err := tx.ExecContext(ctx, insert, version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"log"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ---- MESSAGEPACK ----
+
+// wantsMsgpack reports whether the client asked for a MessagePack result
+// with Accept: application/msgpack (or application/x-msgpack).
+func wantsMsgpack(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, _ := mime.ParseMediaType(strings.TrimSpace(part))
+		if mediaType == "application/msgpack" || mediaType == "application/x-msgpack" {
+			return true
+		}
+	}
+	return false
+}
+
+// msgpackIntTypes and msgpackFloatTypes are the column types whose values
+// are sent as MessagePack numbers even when the driver returned them as
+// text, as MySQL does outside prepared statements. DECIMAL stays a string
+// to keep its precision.
+var (
+	msgpackIntTypes = map[string]bool{
+		"TINYINT": true, "SMALLINT": true, "MEDIUMINT": true, "INT": true, "INTEGER": true, "BIGINT": true,
+		"UNSIGNED TINYINT": true, "UNSIGNED SMALLINT": true, "UNSIGNED MEDIUMINT": true,
+		"UNSIGNED INT": true, "UNSIGNED BIGINT": true, "YEAR": true,
+		"INT2": true, "INT4": true, "INT8": true,
+	}
+	msgpackFloatTypes = map[string]bool{
+		"FLOAT": true, "DOUBLE": true, "FLOAT4": true, "FLOAT8": true,
+	}
+)
+
+// writeMsgpackStream streams a SELECT result as a sequence of MessagePack
+// objects rather than one document, so rows are sent as they are read
+// like the JSON stream: a {"type", "columns"} header map, one map per row,
+// then a {"count", "truncated"} trailer map. Decoders read it with their
+// streaming unpacker. As with the JSON stream, errors after the header
+// can only be logged and leave the trailer missing.
+func writeMsgpackStream(w http.ResponseWriter, r *http.Request, rows *sql.Rows, scanner *rowScanner, queryType string, rowLimit int) int {
+	kinds := map[string]string{}
+	for i, col := range scanner.columns {
+		kinds[col] = scanner.colTypes[i].DatabaseTypeName()
+	}
+
+	w.Header().Set("Content-Type", "application/msgpack")
+	w.WriteHeader(http.StatusOK)
+	enc := &msgpackEncoder{w: bufio.NewWriter(w)}
+	enc.encode(map[string]interface{}{"type": queryType, "columns": scanner.columnInfo()})
+
+	count := 0
+	truncated := false
+	for rows.Next() {
+		if rowLimit > 0 && count >= rowLimit {
+			truncated = true
+			break
+		}
+		row, err := scanner.scan(rows)
+		if err != nil {
+			log.Printf("[%s] msgpack result aborted after %d rows: %v", requestID(r), count, err)
+			enc.w.Flush()
+			return count
+		}
+
+		enc.mapHeader(len(row))
+		for col, v := range row {
+			enc.encode(col)
+			if s, ok := v.(string); ok {
+				v = nativeNumber(kinds[col], s)
+			}
+			enc.encode(v)
+		}
+		count++
+		if count%streamFlushEvery == 0 {
+			enc.w.Flush()
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("[%s] msgpack result aborted after %d rows: %v", requestID(r), count, err)
+		enc.w.Flush()
+		return count
+	}
+
+	tail := map[string]interface{}{"count": count, "truncated": truncated}
+	if scanner.truncatedCells {
+		tail["truncatedCells"] = true
+	}
+	enc.encode(tail)
+	enc.w.Flush()
+	return count
+}
+
+// nativeNumber returns s as an int64, uint64 or float64 when the column
+// type is numeric and s parses as one, otherwise s.
+func nativeNumber(dbType, s string) interface{} {
+	switch {
+	case msgpackIntTypes[dbType]:
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i
+		}
+		if u, err := strconv.ParseUint(s, 10, 64); err == nil {
+			return u
+		}
+	case msgpackFloatTypes[dbType]:
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	}
+	return s
+}
+
+// msgpackEncoder writes the MessagePack encoding of the values results are
+// made of. Anything else goes through its JSON form, so struct tags apply.
+type msgpackEncoder struct {
+	w *bufio.Writer
+}
+
+func (e *msgpackEncoder) encode(v interface{}) {
+	switch val := v.(type) {
+	case nil:
+		e.w.WriteByte(0xc0)
+	case bool:
+		if val {
+			e.w.WriteByte(0xc3)
+		} else {
+			e.w.WriteByte(0xc2)
+		}
+	case int:
+		e.int(int64(val))
+	case int32:
+		e.int(int64(val))
+	case int64:
+		e.int(val)
+	case uint64:
+		if val <= math.MaxInt64 {
+			e.int(int64(val))
+		} else {
+			e.w.WriteByte(0xcf)
+			e.w.Write(binary.BigEndian.AppendUint64(nil, val))
+		}
+	case float32:
+		e.float(float64(val))
+	case float64:
+		e.float(val)
+	case json.Number:
+		if i, err := val.Int64(); err == nil {
+			e.int(i)
+		} else if f, err := val.Float64(); err == nil {
+			e.float(f)
+		} else {
+			e.str(val.String())
+		}
+	case string:
+		e.str(val)
+	case []byte:
+		e.bin(val)
+	case time.Time:
+		e.str(val.Format(time.RFC3339Nano))
+	case map[string]interface{}:
+		e.mapHeader(len(val))
+		for k, item := range val {
+			e.str(k)
+			e.encode(item)
+		}
+	case []interface{}:
+		e.arrayHeader(len(val))
+		for _, item := range val {
+			e.encode(item)
+		}
+	default:
+		var generic interface{}
+		b, err := json.Marshal(val)
+		if err == nil {
+			dec := json.NewDecoder(bytes.NewReader(b))
+			dec.UseNumber()
+			err = dec.Decode(&generic)
+		}
+		if err != nil {
+			e.w.WriteByte(0xc0)
+			return
+		}
+		e.encode(generic)
+	}
+}
+
+func (e *msgpackEncoder) int(i int64) {
+	switch {
+	case i >= 0 && i <= 0x7f:
+		e.w.WriteByte(byte(i))
+	case i < 0 && i >= -32:
+		e.w.WriteByte(byte(i))
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		e.w.Write([]byte{0xd0, byte(i)})
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		e.w.WriteByte(0xd1)
+		e.w.Write(binary.BigEndian.AppendUint16(nil, uint16(i)))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		e.w.WriteByte(0xd2)
+		e.w.Write(binary.BigEndian.AppendUint32(nil, uint32(i)))
+	default:
+		e.w.WriteByte(0xd3)
+		e.w.Write(binary.BigEndian.AppendUint64(nil, uint64(i)))
+	}
+}
+
+func (e *msgpackEncoder) float(f float64) {
+	e.w.WriteByte(0xcb)
+	e.w.Write(binary.BigEndian.AppendUint64(nil, math.Float64bits(f)))
+}
+
+func (e *msgpackEncoder) str(s string) {
+	switch n := len(s); {
+	case n <= 31:
+		e.w.WriteByte(0xa0 | byte(n))
+	case n <= math.MaxUint8:
+		e.w.Write([]byte{0xd9, byte(n)})
+	case n <= math.MaxUint16:
+		e.w.WriteByte(0xda)
+		e.w.Write(binary.BigEndian.AppendUint16(nil, uint16(n)))
+	default:
+		e.w.WriteByte(0xdb)
+		e.w.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+	}
+	e.w.WriteString(s)
+}
+
+func (e *msgpackEncoder) bin(b []byte) {
+	switch n := len(b); {
+	case n <= math.MaxUint8:
+		e.w.Write([]byte{0xc4, byte(n)})
+	case n <= math.MaxUint16:
+		e.w.WriteByte(0xc5)
+		e.w.Write(binary.BigEndian.AppendUint16(nil, uint16(n)))
+	default:
+		e.w.WriteByte(0xc6)
+		e.w.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+	}
+	e.w.Write(b)
+}
+
+func (e *msgpackEncoder) mapHeader(n int) {
+	switch {
+	case n <= 15:
+		e.w.WriteByte(0x80 | byte(n))
+	case n <= math.MaxUint16:
+		e.w.WriteByte(0xde)
+		e.w.Write(binary.BigEndian.AppendUint16(nil, uint16(n)))
+	default:
+		e.w.WriteByte(0xdf)
+		e.w.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+	}
+}
+
+func (e *msgpackEncoder) arrayHeader(n int) {
+	switch {
+	case n <= 15:
+		e.w.WriteByte(0x90 | byte(n))
+	case n <= math.MaxUint16:
+		e.w.WriteByte(0xdc)
+		e.w.Write(binary.BigEndian.AppendUint16(nil, uint16(n)))
+	default:
+		e.w.WriteByte(0xdd)
+		e.w.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ---- NAMED QUERIES ----
+
+const namedQueryCtxKey ctxKey = iota + 200
+
+// namedParam is a named query parameter. Params bind to the statement's
+// placeholders in the order they are listed; Type is an argType.
+type namedParam struct {
+	Name        string `json:"name"`
+	Type        string `json:"type,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// namedQuery is an entry of NAMED_QUERIES_FILE: a saved statement that
+// clients run by name with /queries/{name}, optionally reshaped by a
+// result pipeline.
+type namedQuery struct {
+	SQL         string            `json:"sql"`
+	Description string            `json:"description,omitempty"`
+	Params      []namedParam      `json:"params,omitempty"`
+	Pipeline    []json.RawMessage `json:"pipeline,omitempty"`
+
+	pipeline pipeline
+}
+
+var namedQueries = loadNamedQueries()
+
+func loadNamedQueries() map[string]*namedQuery {
+	if namedQueriesFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(namedQueriesFile)
+	if err != nil {
+		log.Fatalf("NAMED_QUERIES_FILE: %v", err)
+	}
+	var queries map[string]*namedQuery
+	if err := json.Unmarshal(data, &queries); err != nil {
+		log.Fatalf("NAMED_QUERIES_FILE: %v", err)
+	}
+	for name, nq := range queries {
+		if strings.TrimSpace(nq.SQL) == "" {
+			log.Fatalf("NAMED_QUERIES_FILE: %s: sql is required", name)
+		}
+		for i, p := range nq.Params {
+			if p.Name == "" {
+				log.Fatalf("NAMED_QUERIES_FILE: %s: param %d has no name", name, i)
+			}
+			if _, ok := paramSchemaTypes[p.Type]; !ok {
+				log.Fatalf("NAMED_QUERIES_FILE: %s: param %s has unknown type %q", name, p.Name, p.Type)
+			}
+		}
+		if nq.pipeline, err = buildPipeline(nq.Pipeline); err != nil {
+			log.Fatalf("NAMED_QUERIES_FILE: %s: pipeline %v", name, err)
+		}
+	}
+	return queries
+}
+
+// namedQueryFrom returns the named query a request is running, if any.
+func namedQueryFrom(r *http.Request) *namedQuery {
+	nq, _ := r.Context().Value(namedQueryCtxKey).(*namedQuery)
+	return nq
+}
+
+type NamedQueryRequest struct {
+	Params map[string]interface{} `json:"params,omitempty"`
+	Fields []string               `json:"fields,omitempty"`
+	Stream bool                   `json:"stream,omitempty"`
+	Label  string                 `json:"label,omitempty"`
+	Sort   []SortKey              `json:"sort,omitempty"`
+}
+
+// namedQueryHandler runs POST /queries/{name}: the params are bound to
+// the saved statement, which then goes through the /query pipeline (and
+// its checks) as the caller.
+func namedQueryHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	nq := namedQueries[name]
+	if nq == nil {
+		respondJSON(w, r, http.StatusNotFound, ErrorResponse{
+			Error: "Named query not found",
+		})
+		return
+	}
+
+	var req NamedQueryRequest
+	if berr := decodeJSONBody(w, r, &req); berr != nil {
+		respondJSON(w, r, berr.status, ErrorResponse{
+			Error: berr.msg,
+		})
+		return
+	}
+
+	query := QueryRequest{SQL: nq.SQL, Fields: req.Fields, Stream: req.Stream, Label: req.Label, Sort: req.Sort}
+	if query.Label == "" {
+		query.Label = name
+	}
+	for _, p := range nq.Params {
+		v, ok := req.Params[p.Name]
+		if !ok {
+			respondJSON(w, r, http.StatusBadRequest, ErrorResponse{
+				Error:   "Missing parameter",
+				Message: fmt.Sprintf("%s requires parameter %q", name, p.Name),
+			})
+			return
+		}
+		query.Args = append(query.Args, v)
+		query.ArgTypes = append(query.ArgTypes, p.Type)
+		delete(req.Params, p.Name)
+	}
+	for extra := range req.Params {
+		respondJSON(w, r, http.StatusBadRequest, ErrorResponse{
+			Error:   "Unknown parameter",
+			Message: fmt.Sprintf("%s has no parameter %q", name, extra),
+		})
+		return
+	}
+
+	body, err := json.Marshal(query)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+	runReq := r.Clone(context.WithValue(r.Context(), namedQueryCtxKey, nq))
+	runReq.Body = io.NopCloser(bytes.NewReader(body))
+	runReq.ContentLength = int64(len(body))
+	runReq.Header.Del("Content-Encoding")
+	queryHandler(w, runReq)
+}
+
+// paramSchemaTypes maps each argType to the JSON schema a client should
+// send for it. Untyped params take any scalar.
+var paramSchemaTypes = map[string]map[string]string{
+	"":       {},
+	"int":    {"type": "integer"},
+	"float":  {"type": "number"},
+	"string": {"type": "string"},
+	"bool":   {"type": "boolean"},
+	"time":   {"type": "string", "format": "date-time"},
+}
+
+// NamedQueryInfo describes a named query to clients. The SQL itself is
+// left out; the name and params are the interface.
+type NamedQueryInfo struct {
+	Name        string       `json:"name"`
+	Description string       `json:"description,omitempty"`
+	Params      []namedParam `json:"params"`
+}
+
+type NamedQueriesResponse struct {
+	Queries []NamedQueryInfo `json:"queries"`
+}
+
+// namedQueriesHandler lists the named queries, or with ?format=openapi
+// describes them as an OpenAPI paths fragment that clients can generate
+// forms or typed bindings from.
+func namedQueriesHandler(w http.ResponseWriter, r *http.Request) {
+	names := make([]string, 0, len(namedQueries))
+	for name := range namedQueries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if r.URL.Query().Get("format") == "openapi" {
+		respondJSON(w, r, http.StatusOK, namedQueriesOpenAPI(names))
+		return
+	}
+
+	resp := NamedQueriesResponse{Queries: []NamedQueryInfo{}}
+	for _, name := range names {
+		nq := namedQueries[name]
+		params := nq.Params
+		if params == nil {
+			params = []namedParam{}
+		}
+		resp.Queries = append(resp.Queries, NamedQueryInfo{Name: name, Description: nq.Description, Params: params})
+	}
+	respondJSON(w, r, http.StatusOK, resp)
+}
+
+// namedQueriesOpenAPI builds an OpenAPI 3 document with one POST operation
+// per named query, its params spelled out as the request body schema.
+func namedQueriesOpenAPI(names []string) map[string]interface{} {
+	paths := map[string]interface{}{}
+	for _, name := range names {
+		nq := namedQueries[name]
+		properties := map[string]interface{}{}
+		required := []string{}
+		for _, p := range nq.Params {
+			schema := map[string]interface{}{}
+			for k, v := range paramSchemaTypes[p.Type] {
+				schema[k] = v
+			}
+			if p.Description != "" {
+				schema["description"] = p.Description
+			}
+			properties[p.Name] = schema
+			required = append(required, p.Name)
+		}
+		params := map[string]interface{}{
+			"type":                 "object",
+			"properties":           properties,
+			"additionalProperties": false,
+		}
+		if len(required) > 0 {
+			params["required"] = required
+		}
+
+		paths["/queries/"+name] = map[string]interface{}{
+			"post": map[string]interface{}{
+				"operationId": name,
+				"summary":     nq.Description,
+				"requestBody": map[string]interface{}{
+					"required": true,
+					"content": map[string]interface{}{
+						"application/json": map[string]interface{}{
+							"schema": map[string]interface{}{
+								"type": "object",
+								"properties": map[string]interface{}{
+									"params": params,
+									"fields": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+									"stream": map[string]interface{}{"type": "boolean"},
+									"label":  map[string]interface{}{"type": "string"},
+									"sort": map[string]interface{}{"type": "array", "items": map[string]interface{}{
+										"type": "object",
+										"properties": map[string]interface{}{
+											"field": map[string]interface{}{"type": "string"},
+											"dir":   map[string]interface{}{"type": "string", "enum": []string{"asc", "desc"}},
+										},
+										"required": []string{"field"},
+									}},
+								},
+							},
+						},
+					},
+				},
+				"responses": map[string]interface{}{
+					"200": map[string]interface{}{"description": "The query result, as from /query"},
+					"400": map[string]interface{}{"description": "Missing, unknown or invalid params"},
+				},
+			},
+		}
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info":    map[string]interface{}{"title": "go-sql-runner named queries", "version": "1"},
+		"paths":   paths,
+	}
+}
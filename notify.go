@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// ---- LISTEN / NOTIFY ----
+
+var channelPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,62}$`)
+
+type Notification struct {
+	Channel string `json:"channel"`
+	Payload string `json:"payload"`
+	PID     uint32 `json:"pid"`
+}
+
+// openSubscriptions counts subscribers holding a pool connection, for
+// MAX_SUBSCRIPTIONS.
+var openSubscriptions atomic.Int64
+
+// subscribeHandler bridges Postgres LISTEN/NOTIFY to Server-Sent Events.
+// Each subscriber holds a dedicated connection until it disconnects, so
+// at most MAX_SUBSCRIPTIONS may be open at once. Keys with a table
+// allowlist may only listen on channels named in it.
+func subscribeHandler(w http.ResponseWriter, r *http.Request) {
+	if dbDriver != driverPostgres {
+		respondJSON(w, r, http.StatusNotImplemented, ErrorResponse{
+			Error:   "Not supported",
+			Message: "LISTEN/NOTIFY requires DB_DRIVER=postgres",
+		})
+		return
+	}
+
+	channel := r.PathValue("channel")
+	if !channelPattern.MatchString(channel) {
+		respondJSON(w, r, http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid channel name",
+		})
+		return
+	}
+
+	if !tableAllowed(r, strings.ToLower(channel)) {
+		respondJSON(w, r, http.StatusForbidden, ErrorResponse{
+			Error:     "Forbidden",
+			Message:   fmt.Sprintf("channel %s is not permitted for this key", channel),
+			RequestID: requestID(r),
+		})
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	if n := openSubscriptions.Add(1); maxSubscriptions > 0 && n > int64(maxSubscriptions) {
+		openSubscriptions.Add(-1)
+		w.Header().Set("Retry-After", "1")
+		respondJSON(w, r, http.StatusServiceUnavailable, ErrorResponse{
+			Error:     "Too many subscriptions",
+			Message:   fmt.Sprintf("%d subscriptions are already open", maxSubscriptions),
+			RequestID: requestID(r),
+		})
+		return
+	}
+	defer openSubscriptions.Add(-1)
+
+	ctx := r.Context()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+	defer conn.Close()
+
+	listening := false
+	err = conn.Raw(func(driverConn any) error {
+		pgConn := driverConn.(*stdlib.Conn).Conn()
+		ident := pgx.Identifier{channel}.Sanitize()
+
+		if _, err := pgConn.Exec(ctx, "LISTEN "+ident); err != nil {
+			return err
+		}
+		listening = true
+
+		// A subscription stays open indefinitely, so WRITE_TIMEOUT would
+		// cut it off.
+		_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		for {
+			n, err := pgConn.WaitForNotification(ctx)
+			if err != nil {
+				return unlisten(pgConn, ident, ctx.Err() != nil, err)
+			}
+
+			data, _ := json.Marshal(Notification{Channel: n.Channel, Payload: n.Payload, PID: n.PID})
+			fmt.Fprintf(w, "event: notification\ndata: %s\n\n", data)
+			flusher.Flush()
+		}
+	})
+
+	if err != nil {
+		if !listening {
+			respondErr(w, r, err)
+			return
+		}
+		log.Printf("[%s] subscription to %q ended: %v", requestID(r), channel, err)
+	}
+}
+
+// unlisten cleans up after a subscription ends. If the connection can no
+// longer be used (e.g. the driver closed it on cancellation) it reports
+// driver.ErrBadConn so the pool discards it instead of reusing it.
+func unlisten(pgConn *pgx.Conn, ident string, clientGone bool, waitErr error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := pgConn.Exec(ctx, "UNLISTEN "+ident); err != nil {
+		return driver.ErrBadConn
+	}
+	if clientGone {
+		return nil
+	}
+	return waitErr
+}
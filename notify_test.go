@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSubscribeHandlerRefusals(t *testing.T) {
+	savedDriver, savedTables, savedMax := dbDriver, keyTables, maxSubscriptions
+	defer func() {
+		dbDriver, keyTables, maxSubscriptions = savedDriver, savedTables, savedMax
+		openSubscriptions.Store(0)
+	}()
+	dbDriver = driverPostgres
+	keyTables = map[string]map[string]bool{"limited": {"orders": true}}
+	maxSubscriptions = 1
+
+	subscribe := func(key, channel string) int {
+		r := httptest.NewRequest("GET", "/subscribe/"+channel, nil)
+		r.SetPathValue("channel", channel)
+		r = r.WithContext(context.WithValue(r.Context(), apiKeyCtxKey, key))
+		w := httptest.NewRecorder()
+		subscribeHandler(w, r)
+		return w.Code
+	}
+
+	if code := subscribe("limited", "secret"); code != http.StatusForbidden {
+		t.Errorf("restricted key on another channel: status %d, want %d", code, http.StatusForbidden)
+	}
+
+	openSubscriptions.Store(1)
+	if code := subscribe("limited", "orders"); code != http.StatusServiceUnavailable {
+		t.Errorf("over MAX_SUBSCRIPTIONS: status %d, want %d", code, http.StatusServiceUnavailable)
+	}
+	if n := openSubscriptions.Load(); n != 1 {
+		t.Errorf("refused subscription left the count at %d, want 1", n)
+	}
+}
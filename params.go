@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"net/http"
+
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// ---- PARAMETER METADATA ----
+
+// ParamsResponse describes a statement's placeholders. Count is null when
+// the driver can't tell; Types, by position, are only known on Postgres.
+type ParamsResponse struct {
+	Count *int     `json:"count"`
+	Types []string `json:"types,omitempty"`
+}
+
+// paramsHandler prepares a statement without running it and reports the
+// parameters it expects, so a UI can offer the right inputs.
+func paramsHandler(w http.ResponseWriter, r *http.Request) {
+	var req QueryRequest
+	if berr := decodeJSONBody(w, r, &req); berr != nil {
+		respondJSON(w, r, berr.status, ErrorResponse{
+			Error: berr.msg,
+		})
+		return
+	}
+
+	sqlQuery := trimStatement(req.SQL)
+	if sqlQuery == "" {
+		respondJSON(w, r, http.StatusBadRequest, ErrorResponse{
+			Error: "SQL query is required",
+		})
+		return
+	}
+	if !checkSingleStatement(w, r, sqlQuery, nil) {
+		return
+	}
+	sqlQuery = normalizePlaceholders(sqlQuery)
+	if denied := tableRestriction(r, sqlQuery); denied != "" {
+		respondJSON(w, r, http.StatusForbidden, ErrorResponse{
+			Error:     "Forbidden",
+			Message:   fmt.Sprintf("%s is not permitted for this key", denied),
+			RequestID: requestID(r),
+		})
+		return
+	}
+	queryType := statementType(sqlQuery)
+
+	ctx, span := startDBSpan(r.Context(), "PREPARE", sqlQuery)
+	defer span.End()
+	r = r.WithContext(ctx)
+
+	conn, ok := acquireConn(w, r, poolFor(queryType))
+	if !ok {
+		return
+	}
+	defer conn.Close()
+
+	var resp ParamsResponse
+	err := conn.Raw(func(driverConn any) error {
+		var err error
+		resp, err = describeParams(ctx, driverConn, sqlQuery)
+		return err
+	})
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+	respondJSON(w, r, http.StatusOK, resp)
+}
+
+// describeParams prepares sqlQuery on a raw driver connection and closes
+// it again. Postgres reports each parameter's inferred type; other drivers
+// only how many there are, if that.
+func describeParams(ctx context.Context, driverConn any, sqlQuery string) (ParamsResponse, error) {
+	if pc, ok := driverConn.(*stdlib.Conn); ok {
+		// The unnamed statement needs no cleanup; the next one replaces it.
+		sd, err := pc.Conn().PgConn().Prepare(ctx, "", sqlQuery, nil)
+		if err != nil {
+			return ParamsResponse{}, err
+		}
+		types := make([]string, len(sd.ParamOIDs))
+		for i, oid := range sd.ParamOIDs {
+			if t, ok := pc.Conn().TypeMap().TypeForOID(oid); ok {
+				types[i] = t.Name
+			} else {
+				types[i] = fmt.Sprint(oid)
+			}
+		}
+		count := len(types)
+		return ParamsResponse{Count: &count, Types: types}, nil
+	}
+
+	preparer, ok := driverConn.(driver.ConnPrepareContext)
+	if !ok {
+		return ParamsResponse{}, nil
+	}
+	stmt, err := preparer.PrepareContext(ctx, sqlQuery)
+	if err != nil {
+		return ParamsResponse{}, err
+	}
+	defer stmt.Close()
+	var resp ParamsResponse
+	if n := stmt.NumInput(); n >= 0 {
+		resp.Count = &n
+	}
+	return resp, nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// ---- PARQUET ----
+
+// parquetRowGroupSize is how many rows are buffered before a row group is
+// flushed to the client, which bounds memory for large results.
+const parquetRowGroupSize = 10000
+
+type parquetKind int
+
+const (
+	parquetString parquetKind = iota
+	parquetInt64
+	parquetDouble
+	parquetBool
+)
+
+// parquetKindOf picks a Parquet physical type for a database column type.
+// DECIMAL and unsigned BIGINT stay strings to avoid losing precision.
+func parquetKindOf(ct *sql.ColumnType) parquetKind {
+	switch ct.DatabaseTypeName() {
+	case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "BIGINT", "YEAR",
+		"UNSIGNED TINYINT", "UNSIGNED SMALLINT", "UNSIGNED MEDIUMINT", "UNSIGNED INT",
+		"INT2", "INT4", "INT8":
+		return parquetInt64
+	case "FLOAT", "DOUBLE", "FLOAT4", "FLOAT8":
+		return parquetDouble
+	case "BOOL", "BOOLEAN":
+		return parquetBool
+	}
+	return parquetString
+}
+
+func parquetSchema(colTypes []*sql.ColumnType) (*parquet.Schema, []parquetKind) {
+	group := parquet.Group{}
+	kinds := make([]parquetKind, len(colTypes))
+	for i, ct := range colTypes {
+		kinds[i] = parquetKindOf(ct)
+		var node parquet.Node
+		switch kinds[i] {
+		case parquetInt64:
+			node = parquet.Int(64)
+		case parquetDouble:
+			node = parquet.Leaf(parquet.DoubleType)
+		case parquetBool:
+			node = parquet.Leaf(parquet.BooleanType)
+		default:
+			node = parquet.String()
+		}
+		group[ct.Name()] = parquet.Optional(node)
+	}
+	return parquet.NewSchema("row", group), kinds
+}
+
+// parquetValue coerces a converted cell to the Go type the schema expects;
+// the MySQL text protocol hands numbers over as strings.
+func parquetValue(kind parquetKind, v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	s, isString := v.(string)
+	switch kind {
+	case parquetInt64:
+		if isString {
+			if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+				return n
+			}
+			return nil
+		}
+		switch n := v.(type) {
+		case int64:
+			return n
+		case int32:
+			return int64(n)
+		case int16:
+			return int64(n)
+		case int8:
+			return int64(n)
+		case uint64:
+			return int64(n)
+		}
+	case parquetDouble:
+		if isString {
+			if f, err := strconv.ParseFloat(s, 64); err == nil {
+				return f
+			}
+			return nil
+		}
+		switch f := v.(type) {
+		case float64:
+			return f
+		case float32:
+			return float64(f)
+		}
+	case parquetBool:
+		if isString {
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				return nil
+			}
+			return b
+		}
+		if b, ok := v.(bool); ok {
+			return b
+		}
+	default:
+		if isString {
+			return s
+		}
+		return fmt.Sprint(v)
+	}
+	return nil
+}
+
+// writeParquet streams rows to w as a Parquet file, flushing a row group
+// every parquetRowGroupSize rows. Once the body has started, errors can
+// only be logged; the client sees a truncated file.
+func writeParquet(w http.ResponseWriter, r *http.Request, rows *sql.Rows, scanner *rowScanner, rowLimit int) int {
+	colTypes := scanner.outputTypes()
+	columns := columnNames(colTypes)
+	schema, kinds := parquetSchema(colTypes)
+
+	w.Header().Set("Content-Type", "application/vnd.apache.parquet")
+	w.Header().Set("Content-Disposition", `attachment; filename="result.parquet"`)
+	w.WriteHeader(http.StatusOK)
+
+	pw := parquet.NewWriter(w, schema)
+	count := 0
+
+	for rows.Next() {
+		if rowLimit > 0 && count >= rowLimit {
+			break
+		}
+
+		row, err := scanner.scan(rows)
+		if err != nil {
+			log.Printf("[%s] parquet export aborted: %v", requestID(r), err)
+			return count
+		}
+
+		for i, col := range columns {
+			row[col] = parquetValue(kinds[i], row[col])
+		}
+		if err := pw.Write(row); err != nil {
+			log.Printf("[%s] parquet export aborted: %v", requestID(r), err)
+			return count
+		}
+
+		count++
+		if count%parquetRowGroupSize == 0 {
+			if err := pw.Flush(); err != nil {
+				log.Printf("[%s] parquet export aborted: %v", requestID(r), err)
+				return count
+			}
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Printf("[%s] parquet export aborted: %v", requestID(r), err)
+		return count
+	}
+	if err := pw.Close(); err != nil {
+		log.Printf("[%s] parquet export aborted: %v", requestID(r), err)
+	}
+	return count
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ---- RESULT PIPELINE ----
+
+// resultStage is one step of a named query's result pipeline. It reshapes
+// each row as it is scanned, so the same stages serve buffered and
+// streamed results, and describes what it does to the columns.
+type resultStage interface {
+	row(row map[string]interface{}) map[string]interface{}
+	columns(cols []ColumnInfo) []ColumnInfo
+}
+
+// stageFactory builds a stage from its entry in a named query's
+// "pipeline", e.g. {"stage": "rename", "columns": {"nm": "name"}}.
+type stageFactory func(opts json.RawMessage) (resultStage, error)
+
+// stages are the steps a named query's pipeline can list, by the name its
+// entries give as "stage".
+var stages = map[string]stageFactory{
+	"rename":      newRenameStage,
+	"flattenJson": newFlattenJSONStage,
+}
+
+// pipeline runs its stages in order.
+type pipeline []resultStage
+
+func buildPipeline(specs []json.RawMessage) (pipeline, error) {
+	if len(specs) == 0 {
+		return nil, nil
+	}
+	p := make(pipeline, 0, len(specs))
+	for i, raw := range specs {
+		var spec struct {
+			Stage string `json:"stage"`
+		}
+		if err := json.Unmarshal(raw, &spec); err != nil {
+			return nil, fmt.Errorf("stage %d: %w", i, err)
+		}
+		factory, ok := stages[spec.Stage]
+		if !ok {
+			return nil, fmt.Errorf("stage %d: unknown stage %q", i, spec.Stage)
+		}
+		stage, err := factory(raw)
+		if err != nil {
+			return nil, fmt.Errorf("stage %d (%s): %w", i, spec.Stage, err)
+		}
+		p = append(p, stage)
+	}
+	return p, nil
+}
+
+func (p pipeline) row(row map[string]interface{}) map[string]interface{} {
+	for _, stage := range p {
+		row = stage.row(row)
+	}
+	return row
+}
+
+func (p pipeline) columns(cols []ColumnInfo) []ColumnInfo {
+	for _, stage := range p {
+		cols = stage.columns(cols)
+	}
+	return cols
+}
+
+// renameStage renames result columns per "columns": {"old": "new"}.
+type renameStage map[string]string
+
+func newRenameStage(opts json.RawMessage) (resultStage, error) {
+	var cfg struct {
+		Columns map[string]string `json:"columns"`
+	}
+	if err := json.Unmarshal(opts, &cfg); err != nil {
+		return nil, err
+	}
+	if len(cfg.Columns) == 0 {
+		return nil, fmt.Errorf("columns must not be empty")
+	}
+	return renameStage(cfg.Columns), nil
+}
+
+func (s renameStage) row(row map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(row))
+	for col, v := range row {
+		if to, ok := s[col]; ok {
+			col = to
+		}
+		out[col] = v
+	}
+	return out
+}
+
+func (s renameStage) columns(cols []ColumnInfo) []ColumnInfo {
+	out := make([]ColumnInfo, len(cols))
+	for i, c := range cols {
+		if to, ok := s[c.Name]; ok {
+			c.Name = to
+		}
+		out[i] = c
+	}
+	return out
+}
+
+// flattenJSONStage replaces a JSON object column with one top-level column
+// per key, named prefix+key (prefix defaults to "column."). Values that
+// aren't JSON objects are left in place. The keys vary by row, so the
+// flattened columns aren't listed in the column metadata.
+type flattenJSONStage struct {
+	column, prefix string
+}
+
+func newFlattenJSONStage(opts json.RawMessage) (resultStage, error) {
+	var cfg struct {
+		Column string  `json:"column"`
+		Prefix *string `json:"prefix"`
+	}
+	if err := json.Unmarshal(opts, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Column == "" {
+		return nil, fmt.Errorf("column is required")
+	}
+	s := flattenJSONStage{column: cfg.Column, prefix: cfg.Column + "."}
+	if cfg.Prefix != nil {
+		s.prefix = *cfg.Prefix
+	}
+	return s, nil
+}
+
+func (s flattenJSONStage) row(row map[string]interface{}) map[string]interface{} {
+	var obj map[string]interface{}
+	switch v := row[s.column].(type) {
+	case map[string]interface{}:
+		obj = v
+	case string:
+		if json.Unmarshal([]byte(v), &obj) != nil {
+			return row
+		}
+	}
+	if obj == nil {
+		return row
+	}
+	delete(row, s.column)
+	for k, v := range obj {
+		row[s.prefix+k] = v
+	}
+	return row
+}
+
+func (s flattenJSONStage) columns(cols []ColumnInfo) []ColumnInfo {
+	out := make([]ColumnInfo, 0, len(cols))
+	for _, c := range cols {
+		if c.Name != s.column {
+			out = append(out, c)
+		}
+	}
+	return out
+}
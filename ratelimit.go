@@ -0,0 +1,88 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// ---- RATE LIMITING ----
+
+// maxIdleBuckets bounds how many callers a limiter tracks before it prunes
+// those whose bucket has refilled.
+const maxIdleBuckets = 10000
+
+// rateLimiter is a per-key token bucket allowing perMinute requests a
+// minute, with bursts up to the same amount.
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64 // tokens per second
+	burst   float64
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter returns nil when perMinute is 0, meaning unlimited.
+func newRateLimiter(perMinute int) *rateLimiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	return &rateLimiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(perMinute),
+		buckets: map[string]*bucket{},
+	}
+}
+
+func (l *rateLimiter) allow(key string) bool {
+	if l == nil {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxIdleBuckets {
+			l.pruneLocked(now)
+		}
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// pruneLocked forgets callers whose buckets are full again, since a fresh
+// bucket would behave identically.
+func (l *rateLimiter) pruneLocked(now time.Time) {
+	for k, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+// retryAfterSeconds is how long until a drained bucket earns a token.
+func (l *rateLimiter) retryAfterSeconds() int {
+	return int(1/l.rate) + 1
+}
+
+// rateLimitKey identifies a caller: its API key, or its IP when auth is off.
+func rateLimitKey(key, ip string) string {
+	if key != "" {
+		return "key:" + key
+	}
+	return "ip:" + ip
+}
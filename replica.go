@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ---- REPLICA LAG ----
+
+// replicaLagTimeout bounds one lag measurement, so a hung replica can't
+// hold up /health or the reads routed by it.
+const replicaLagTimeout = 2 * time.Second
+
+// replicaLagCache remembers the read replica's last measured lag for
+// REPLICA_LAG_CACHE, so routing decisions don't each cost a round trip.
+// refreshing is set while one caller measures it again; the others keep
+// using the previous value meanwhile.
+var replicaLagCache struct {
+	mu         sync.Mutex
+	lag        float64
+	ok         bool
+	fetched    time.Time
+	refreshing bool
+}
+
+// replicaLag returns how many seconds the read replica is behind the
+// primary. ok is false when that isn't known: replication is stopped, the
+// lag query failed or timed out, or there is no replica.
+func replicaLag(ctx context.Context) (lag float64, ok bool) {
+	if readDB == nil {
+		return 0, false
+	}
+
+	replicaLagCache.mu.Lock()
+	fresh := !replicaLagCache.fetched.IsZero() && time.Since(replicaLagCache.fetched) < replicaLagCacheTTL
+	if fresh || replicaLagCache.refreshing {
+		lag, ok = replicaLagCache.lag, replicaLagCache.ok
+		replicaLagCache.mu.Unlock()
+		return lag, ok
+	}
+	replicaLagCache.refreshing = true
+	replicaLagCache.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), replicaLagTimeout)
+	defer cancel()
+	lag, ok, err := measureReplicaLag(ctx)
+	if err != nil {
+		log.Printf("replica lag check failed: %v", err)
+	}
+
+	replicaLagCache.mu.Lock()
+	replicaLagCache.lag, replicaLagCache.ok, replicaLagCache.fetched = lag, ok, time.Now()
+	replicaLagCache.refreshing = false
+	replicaLagCache.mu.Unlock()
+	return lag, ok
+}
+
+func measureReplicaLag(ctx context.Context) (float64, bool, error) {
+	if dbDriver == driverPostgres {
+		// NULL on a server that isn't replaying WAL. An idle primary makes
+		// the lag grow too, since no new transactions are replayed.
+		var lag sql.NullFloat64
+		err := readDB.QueryRowContext(ctx,
+			"SELECT EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp())").Scan(&lag)
+		return lag.Float64, lag.Valid, err
+	}
+
+	// SHOW REPLICA STATUS (8.0.22+) names the column Seconds_Behind_Source;
+	// older servers only know SHOW SLAVE STATUS and Seconds_Behind_Master.
+	rows, err := readDB.QueryContext(ctx, "SHOW REPLICA STATUS")
+	if err != nil {
+		rows, err = readDB.QueryContext(ctx, "SHOW SLAVE STATUS")
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return 0, false, err
+	}
+	if !rows.Next() {
+		return 0, false, rows.Err()
+	}
+	values := make([]sql.NullString, len(cols))
+	ptrs := make([]interface{}, len(cols))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	if err := rows.Scan(ptrs...); err != nil {
+		return 0, false, err
+	}
+	for i, col := range cols {
+		if col != "Seconds_Behind_Source" && col != "Seconds_Behind_Master" {
+			continue
+		}
+		// NULL while the replication threads aren't running.
+		if !values[i].Valid {
+			return 0, false, nil
+		}
+		lag, err := strconv.ParseFloat(values[i].String, 64)
+		return lag, err == nil, err
+	}
+	return 0, false, nil
+}
+
+// poolWithinLag picks the pool for a read that tolerates at most maxLag
+// seconds of replication lag: the replica when it is known to be within
+// that, otherwise the primary. 0 always means the primary.
+func poolWithinLag(ctx context.Context, maxLag float64) *sql.DB {
+	if readDB == nil || maxLag <= 0 {
+		return db
+	}
+	if lag, ok := replicaLag(ctx); ok && lag <= maxLag {
+		return readDB
+	}
+	return db
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ---- RESPONSE TYPES ----
+
+type ErrorResponse struct {
+	Error     string `json:"error"`
+	Message   string `json:"message,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+
+	// Statement is the index of the offending statement in a transaction.
+	Statement *int `json:"statement,omitempty"`
+
+	// Code classifies a database error the same way on every backend;
+	// see errorCode.
+	Code string `json:"code,omitempty"`
+}
+
+// ProblemDetails is the RFC 7807 rendering of an ErrorResponse, used with
+// ERROR_FORMAT=problem.
+type ProblemDetails struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	Statement *int   `json:"statement,omitempty"`
+	Code      string `json:"code,omitempty"`
+}
+
+func problemFrom(e ErrorResponse, status int, reqID string) ProblemDetails {
+	if e.RequestID != "" {
+		reqID = e.RequestID
+	}
+	return ProblemDetails{
+		Type:      "about:blank",
+		Title:     e.Error,
+		Status:    status,
+		Detail:    e.Message,
+		Instance:  reqID,
+		Statement: e.Statement,
+		Code:      e.Code,
+	}
+}
+
+type Warning struct {
+	Level   string `json:"level"`
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// SelectResponse, ExecResponse and DDLResponse are the wire format of
+// /query; add new response fields here rather than in the handler.
+type SelectResponse struct {
+	Type      string                   `json:"type"`
+	Columns   []ColumnInfo             `json:"columns"`
+	Rows      []map[string]interface{} `json:"rows"`
+	Count     int                      `json:"count"`
+	Truncated bool                     `json:"truncated,omitempty"`
+	Warnings  []Warning                `json:"warnings,omitempty"`
+
+	// TruncatedCells reports that some cells were cut to MAX_CELL_BYTES.
+	TruncatedCells bool `json:"truncatedCells,omitempty"`
+
+	Meta *ResponseMeta `json:"meta,omitempty"`
+
+	// Checksum hashes the rows (?checksum=true|only); with only, Rows is
+	// left empty and Count still reports how many there were.
+	Checksum string `json:"checksum,omitempty"`
+
+	// ResultSets lists every result set, the first included, when the
+	// statement returned more than one.
+	ResultSets []ResultSet `json:"resultSets,omitempty"`
+}
+
+type ResultSet struct {
+	Columns        []ColumnInfo             `json:"columns"`
+	Rows           []map[string]interface{} `json:"rows"`
+	Count          int                      `json:"count"`
+	Truncated      bool                     `json:"truncated,omitempty"`
+	TruncatedCells bool                     `json:"truncatedCells,omitempty"`
+}
+
+// ResponseMeta carries optional diagnostics about how a query ran.
+type ResponseMeta struct {
+	EstimatedRows float64                  `json:"estimatedRows,omitempty"`
+	CostWarning   string                   `json:"costWarning,omitempty"`
+	Plan          []map[string]interface{} `json:"plan,omitempty"`
+
+	// RowsExamined is MySQL's count of rows read to produce the result
+	// (?stats=true). Far more than Count usually means a missing index.
+	RowsExamined *int64 `json:"rowsExamined,omitempty"`
+
+	// Attempts is how many times a transaction ran, more than one when it
+	// was retried after a deadlock.
+	Attempts int `json:"attempts,omitempty"`
+
+	// Timing breaks down where the request's time went (?trace=true).
+	Timing *QueryTiming `json:"timing,omitempty"`
+}
+
+// QueryTiming is the ?trace=true breakdown of a /query request, in
+// milliseconds: waiting for a connection, running the statement until the
+// first result, reading and converting rows, and JSON-encoding them. The
+// encoding is measured with a trial encode of each row, so tracing adds
+// that cost to the request. TotalMs runs from the start of the handler
+// until the response is written.
+type QueryTiming struct {
+	AcquireMs float64 `json:"acquireMs"`
+	ExecuteMs float64 `json:"executeMs"`
+	ScanMs    float64 `json:"scanMs,omitempty"`
+	EncodeMs  float64 `json:"encodeMs,omitempty"`
+	TotalMs   float64 `json:"totalMs"`
+}
+
+type ExecResponse struct {
+	Type         string `json:"type"`
+	AffectedRows int64  `json:"affectedRows"`
+	InsertID     *int64 `json:"insertId,omitempty"`
+
+	// AffectedKeys lists the primary keys of the touched rows when
+	// returnKeys was requested.
+	AffectedKeys []map[string]interface{} `json:"affectedKeys,omitempty"`
+	Warnings     []Warning                `json:"warnings,omitempty"`
+	Meta         *ResponseMeta            `json:"meta,omitempty"`
+}
+
+type DDLResponse struct {
+	Type       string        `json:"type"`
+	Status     string        `json:"status"`
+	Definition string        `json:"definition,omitempty"`
+	Warnings   []Warning     `json:"warnings,omitempty"`
+	Meta       *ResponseMeta `json:"meta,omitempty"`
+}
+
+// ColumnInfo describes a result column. Nullable and Length are omitted
+// when the driver doesn't report them; Note explains values that could not
+// be represented.
+type ColumnInfo struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Nullable *bool  `json:"nullable,omitempty"`
+	Length   *int64 `json:"length,omitempty"`
+	ScanType string `json:"scanType,omitempty"`
+	Note     string `json:"note,omitempty"`
+}
+
+type StatusResponse struct {
+	Status string `json:"status"`
+}
+
+type HealthResponse struct {
+	Status  string               `json:"status"`
+	Breaker string               `json:"breaker"`
+	Pools   map[string]PoolStats `json:"pools"`
+
+	// Concurrency is omitted when MAX_CONCURRENCY is unset.
+	Concurrency *ConcurrencyStats `json:"concurrency,omitempty"`
+
+	// OpenTransactions counts open sessions and /transaction batches.
+	OpenTransactions int64 `json:"openTransactions"`
+
+	// ReplicaLagSeconds is how far the read replica is behind, when there
+	// is one and its lag is known.
+	ReplicaLagSeconds *float64 `json:"replicaLagSeconds,omitempty"`
+}
+
+type ConcurrencyStats struct {
+	Limit  int   `json:"limit"`
+	Active int   `json:"active"`
+	Queued int64 `json:"queued"`
+}
+
+type PoolStats struct {
+	OpenConnections int   `json:"openConnections"`
+	InUse           int   `json:"inUse"`
+	Idle            int   `json:"idle"`
+	WaitCount       int64 `json:"waitCount"`
+}
+
+// ---- RESPONSE NAMING ----
+
+const (
+	namingCamel = "camelCase"
+	namingSnake = "snake_case"
+)
+
+// jsonObject is a JSON object whose keys keep their insertion order.
+type jsonObject []jsonField
+
+type jsonField struct {
+	name  string
+	value interface{}
+}
+
+func (o jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(f.name)
+		if err != nil {
+			return nil, err
+		}
+		val, err := json.Marshal(f.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(val)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+var jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// applyNaming rewrites the JSON names of struct fields in v according to
+// the configured naming convention. Map keys are data (column names) and
+// are left untouched.
+func applyNaming(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	if v.Type().Implements(jsonMarshalerType) {
+		return v.Interface()
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return applyNaming(v.Elem())
+
+	case reflect.Struct:
+		t := v.Type()
+		obj := make(jsonObject, 0, t.NumField())
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			if !sf.IsExported() {
+				continue
+			}
+			name, opts, _ := strings.Cut(sf.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = sf.Name
+			}
+			fv := v.Field(i)
+			if strings.Contains(opts, "omitempty") && isEmptyValue(fv) {
+				continue
+			}
+			obj = append(obj, jsonField{name: fieldName(name), value: applyNaming(fv)})
+		}
+		return obj
+
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return v.Interface()
+		}
+		out := make([]interface{}, v.Len())
+		for i := range out {
+			out[i] = applyNaming(v.Index(i))
+		}
+		return out
+
+	case reflect.Map:
+		if v.IsNil() || v.Type().Key().Kind() != reflect.String {
+			return v.Interface()
+		}
+		out := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			out[iter.Key().String()] = applyNaming(iter.Value())
+		}
+		return out
+	}
+
+	return v.Interface()
+}
+
+// isEmptyValue mirrors encoding/json's omitempty rules.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Pointer, reflect.Interface:
+		return v.IsNil()
+	}
+	return v.IsZero()
+}
+
+// fieldName converts a camelCase field name to the configured convention.
+func fieldName(name string) string {
+	if responseNaming != namingSnake {
+		return name
+	}
+
+	var b strings.Builder
+	for i, c := range name {
+		if unicode.IsUpper(c) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			c = unicode.ToLower(c)
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// milliseconds converts d for the *Ms fields, to microsecond precision.
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"regexp"
+)
+
+// ---- QUERY REWRITING ----
+
+// rewriteRule replaces the first match of Pattern in a query with Replace,
+// which may reference capture groups as $1 or ${name}.
+type rewriteRule struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+	Replace string `json:"replace"`
+
+	re *regexp.Regexp
+}
+
+// rewriteRules is loaded from REWRITE_RULES_FILE, a JSON array of rules.
+// Rules are tried in order and only the first matching one is applied.
+var rewriteRules = loadRewriteRules()
+
+func loadRewriteRules() []rewriteRule {
+	if rewriteRulesFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(rewriteRulesFile)
+	if err != nil {
+		log.Fatalf("REWRITE_RULES_FILE: %v", err)
+	}
+	var rules []rewriteRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		log.Fatalf("REWRITE_RULES_FILE: %v", err)
+	}
+	for i := range rules {
+		if rules[i].Name == "" {
+			log.Fatalf("REWRITE_RULES_FILE: rule %d has no name", i)
+		}
+		re, err := regexp.Compile(rules[i].Pattern)
+		if err != nil {
+			log.Fatalf("REWRITE_RULES_FILE: rule %q: %v", rules[i].Name, err)
+		}
+		rules[i].re = re
+	}
+	return rules
+}
+
+// rewriteQuery applies the first rule matching sqlQuery. The rewritten
+// statement is prepared on q before being used; if the database rejects
+// it the original query runs unchanged. Either way the outcome is logged.
+func rewriteQuery(ctx context.Context, q queryer, reqID, sqlQuery string) string {
+	for _, rule := range rewriteRules {
+		loc := rule.re.FindStringSubmatchIndex(sqlQuery)
+		if loc == nil {
+			continue
+		}
+
+		expanded := rule.re.ExpandString(nil, rule.Replace, sqlQuery, loc)
+		rewritten := sqlQuery[:loc[0]] + string(expanded) + sqlQuery[loc[1]:]
+
+		stmt, err := q.PrepareContext(ctx, rewritten)
+		if err != nil {
+			log.Printf("[%s] rewrite %q skipped, rewritten query is invalid: %v", reqID, rule.Name, err)
+			return sqlQuery
+		}
+		stmt.Close()
+
+		log.Printf("[%s] rewrite %q applied", reqID, rule.Name)
+		return rewritten
+	}
+	return sqlQuery
+}
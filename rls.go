@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"regexp"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ---- ROW-LEVEL SECURITY ----
+
+const (
+	rlsRole    = "role"
+	rlsSetting = "setting"
+)
+
+var (
+	dbIdentityPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,62}$`)
+
+	errNoDBIdentity = errors.New("no database identity configured for this API key")
+)
+
+// queryer is satisfied by *sql.Conn and *sql.Tx, so statements can run
+// either directly on a dedicated connection or inside a transaction.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
+// dbIdentityFor returns the database user mapped to an API key via
+// API_KEY_USERS.
+func dbIdentityFor(key string) (string, error) {
+	user, ok := apiKeyUsers[key]
+	if !ok || key == "" || !dbIdentityPattern.MatchString(user) {
+		return "", errNoDBIdentity
+	}
+	return user, nil
+}
+
+// applyDBIdentity scopes the caller's identity to tx, either by switching
+// role or by setting RLS_SETTING for policies to read via
+// current_setting(). Both are transaction-local and reset on commit or
+// rollback, so nothing leaks back into the pool.
+func applyDBIdentity(ctx context.Context, tx *sql.Tx, user string) error {
+	if rlsMode == rlsRole {
+		_, err := tx.ExecContext(ctx, "SET LOCAL ROLE "+pgx.Identifier{user}.Sanitize())
+		return err
+	}
+	_, err := tx.ExecContext(ctx, "SELECT set_config($1, $2, true)", rlsSettingName, user)
+	return err
+}
+
+// readAsCaller acquires a connection for a read and, with RLS_MODE, opens
+// a transaction on it carrying the caller's database identity, so the
+// read sees only what the caller's policies allow. It answers the request
+// and returns false when that fails; otherwise done releases it all.
+func readAsCaller(w http.ResponseWriter, r *http.Request, queryType string) (q queryer, done func(), ok bool) {
+	conn, ok := acquireConn(w, r, poolFor(queryType))
+	if !ok {
+		return nil, nil, false
+	}
+	if rlsMode == "" {
+		return conn, func() { conn.Close() }, true
+	}
+
+	user, err := dbIdentityFor(apiKey(r))
+	if err != nil {
+		conn.Close()
+		respondJSON(w, r, http.StatusForbidden, ErrorResponse{
+			Error:   "Forbidden",
+			Message: err.Error(),
+		})
+		return nil, nil, false
+	}
+	tx, err := conn.BeginTx(r.Context(), nil)
+	if err == nil {
+		if err = applyDBIdentity(r.Context(), tx, user); err != nil {
+			_ = tx.Rollback()
+		}
+	}
+	if err != nil {
+		conn.Close()
+		respondErr(w, r, err)
+		return nil, nil, false
+	}
+	return tx, func() {
+		_ = tx.Rollback()
+		conn.Close()
+	}, true
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDBIdentityFor(t *testing.T) {
+	saved := apiKeyUsers
+	defer func() { apiKeyUsers = saved }()
+	apiKeyUsers = map[string]string{
+		"k1":  "app_reader",
+		"bad": `x"; RESET ROLE; --`,
+		"":    "anonymous",
+	}
+
+	if user, err := dbIdentityFor("k1"); err != nil || user != "app_reader" {
+		t.Errorf("dbIdentityFor(k1) = %q, %v; want app_reader", user, err)
+	}
+	for _, key := range []string{"unmapped", "bad", ""} {
+		if _, err := dbIdentityFor(key); !errors.Is(err, errNoDBIdentity) {
+			t.Errorf("dbIdentityFor(%q) = %v, want errNoDBIdentity", key, err)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+// ---- ROUTES ----
+
+type route struct {
+	Path        string
+	Methods     []string
+	Description string
+}
+
+// routes records everything registered through handle, in order, for the
+// landing page.
+var routes []route
+
+// handle registers h for path under each of methods. path is a ServeMux
+// pattern and may hold wildcards such as {id}, read with r.PathValue;
+// requests with any other method get 405 with an Allow header.
+func handle(path string, methods []string, description string, h http.HandlerFunc) {
+	routes = append(routes, route{Path: strings.TrimSuffix(path, "{$}"), Methods: methods, Description: description})
+	for _, m := range methods {
+		http.HandleFunc(m+" "+path, h)
+	}
+}
+
+var landingTemplate = template.Must(template.New("landing").Funcs(template.FuncMap{
+	"join": strings.Join,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>go-sql-runner</title></head>
+<body>
+<h1>go-sql-runner</h1>
+<table>
+<tr><th>Method</th><th>Path</th><th>Description</th></tr>
+{{range .}}<tr><td>{{join .Methods ", "}}</td><td><code>{{.Path}}</code></td><td>{{.Description}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// rootHandler answers {"status":"ok"} for programmatic callers and, when a
+// browser asks for text/html, lists the registered endpoints.
+func rootHandler(w http.ResponseWriter, r *http.Request) {
+	if landingPage && strings.Contains(r.Header.Get("Accept"), "text/html") {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = landingTemplate.Execute(w, routes)
+		return
+	}
+	respondJSON(w, r, http.StatusOK, StatusResponse{Status: "ok"})
+}
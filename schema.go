@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// ---- DDL DEFINITIONS ----
+
+// ddlTarget returns the table a single-table DDL statement leaves behind,
+// as written: CREATE TABLE, CREATE INDEX ... ON, ALTER TABLE (following
+// RENAME TO) and a one-pair RENAME TABLE. It returns nil for anything
+// else, including statements that drop the table.
+func ddlTarget(sql string) []token {
+	toks := significant(tokenize(sql))
+	at := func(p int) token {
+		if p < len(toks) {
+			return toks[p]
+		}
+		return token{}
+	}
+	skip := func(p int, words ...string) int {
+		for {
+			matched := false
+			for _, w := range words {
+				if at(p).is(w) {
+					p++
+					matched = true
+				}
+			}
+			if !matched {
+				return p
+			}
+		}
+	}
+	name := func(p int) ([]token, int) {
+		if !isIdent(at(p)) {
+			return nil, p
+		}
+		q := p + 1
+		for at(q).text == "." && isIdent(at(q+1)) {
+			q += 2
+		}
+		return toks[p:q], q
+	}
+
+	switch {
+	case at(0).is("CREATE"):
+		p := skip(1, "OR", "REPLACE", "GLOBAL", "LOCAL", "TEMPORARY", "TEMP", "UNLOGGED", "UNIQUE")
+		switch {
+		case at(p).is("TABLE"):
+			table, _ := name(skip(p+1, "IF", "NOT", "EXISTS"))
+			return table
+		case at(p).is("INDEX"):
+			depth := 0
+			for q := p + 1; q < len(toks); q++ {
+				switch {
+				case toks[q].text == "(":
+					depth++
+				case toks[q].text == ")":
+					depth--
+				case depth == 0 && toks[q].is("ON"):
+					table, _ := name(skip(q+1, "ONLY"))
+					return table
+				}
+			}
+		}
+
+	case at(0).is("ALTER") && at(1).is("TABLE"):
+		table, p := name(skip(2, "IF", "EXISTS", "ONLY"))
+		if table == nil {
+			return nil
+		}
+		depth := 0
+		for q := p; q < len(toks); q++ {
+			switch {
+			case toks[q].text == "(":
+				depth++
+			case toks[q].text == ")":
+				depth--
+			case depth == 0 && toks[q].is("RENAME") && (at(q+1).is("TO") || at(q+1).is("AS")):
+				renamed, _ := name(q + 2)
+				if renamed == nil {
+					return nil
+				}
+				// Postgres renames within the schema and won't take a
+				// qualified new name.
+				if dbDriver == driverPostgres && len(table) > 1 {
+					renamed = append(append([]token{}, table[:len(table)-1]...), renamed...)
+				}
+				return renamed
+			}
+		}
+		return table
+
+	case at(0).is("RENAME") && at(1).is("TABLE"):
+		_, p := name(2)
+		if !at(p).is("TO") {
+			return nil
+		}
+		renamed, p := name(p + 1)
+		if p < len(toks) {
+			return nil // several renames
+		}
+		return renamed
+	}
+	return nil
+}
+
+// tableDefinition returns the CREATE TABLE statement for table. MySQL
+// reports it with SHOW CREATE TABLE; Postgres has no equivalent, so it is
+// assembled from the catalog: columns with their types, NOT NULL and
+// defaults, followed by table constraints.
+func tableDefinition(ctx context.Context, q queryer, table []token) (string, error) {
+	name := tokensText(table)
+	if dbDriver != driverPostgres {
+		rows, err := q.QueryContext(ctx, "SHOW CREATE TABLE "+name)
+		if err != nil {
+			return "", err
+		}
+		defer rows.Close()
+		var tableName, definition string
+		if rows.Next() {
+			err = rows.Scan(&tableName, &definition)
+		}
+		if err == nil {
+			err = rows.Err()
+		}
+		return definition, err
+	}
+
+	var lines []string
+	rows, err := q.QueryContext(ctx, `SELECT a.attname, format_type(a.atttypid, a.atttypmod), a.attnotnull,
+			pg_get_expr(d.adbin, d.adrelid)
+		FROM pg_attribute a
+		LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+		WHERE a.attrelid = $1::regclass AND a.attnum > 0 AND NOT a.attisdropped
+		ORDER BY a.attnum`, name)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var col, typ string
+		var notNull bool
+		var def *string
+		if err := rows.Scan(&col, &typ, &notNull, &def); err != nil {
+			return "", err
+		}
+		line := quoteIdent(col) + " " + typ
+		if notNull {
+			line += " NOT NULL"
+		}
+		if def != nil {
+			line += " DEFAULT " + *def
+		}
+		lines = append(lines, line)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	crows, err := q.QueryContext(ctx, `SELECT conname, pg_get_constraintdef(oid)
+		FROM pg_constraint WHERE conrelid = $1::regclass ORDER BY contype, conname`, name)
+	if err != nil {
+		return "", err
+	}
+	defer crows.Close()
+	for crows.Next() {
+		var con, def string
+		if err := crows.Scan(&con, &def); err != nil {
+			return "", err
+		}
+		lines = append(lines, "CONSTRAINT "+quoteIdent(con)+" "+def)
+	}
+	if err := crows.Err(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("CREATE TABLE %s (\n  %s\n)", name, strings.Join(lines, ",\n  ")), nil
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ---- SESSIONS ----
+
+// session is an interactive transaction held open across requests on a
+// dedicated connection. mu serialises the requests using it.
+type session struct {
+	id     string
+	key    string
+	conn   *sql.Conn
+	tx     *sql.Tx
+	cancel context.CancelFunc
+	idle   *time.Timer
+
+	mu     sync.Mutex
+	closed bool
+}
+
+type SessionResponse struct {
+	SessionID   string `json:"sessionId"`
+	IdleTimeout string `json:"idleTimeout"`
+}
+
+// sessionsOpening counts sessions being begun; see reserveSlot.
+var (
+	sessionsMu      sync.Mutex
+	sessions        = map[string]*session{}
+	sessionsOpening int
+)
+
+// end rolls back or commits the transaction and returns the connection to
+// the pool. It is a no-op once the session has ended.
+func (s *session) end(commit bool) error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	s.idle.Stop()
+	sessionsMu.Lock()
+	delete(sessions, s.id)
+	sessionsMu.Unlock()
+
+	var err error
+	if commit {
+		err = s.tx.Commit()
+	} else {
+		err = s.tx.Rollback()
+	}
+	s.cancel()
+	_ = s.conn.Close()
+	releaseTransaction()
+	return err
+}
+
+// expire rolls back a session nobody has touched for SESSION_IDLE_TIMEOUT.
+func (s *session) expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		log.Printf("session %s idle for %s, rolling back", s.id, sessionIdleTimeout)
+		_ = s.end(false)
+	}
+}
+
+// closeSessions rolls back every open session, for shutdown.
+func closeSessions() {
+	sessionsMu.Lock()
+	open := make([]*session, 0, len(sessions))
+	for _, s := range sessions {
+		open = append(open, s)
+	}
+	sessionsMu.Unlock()
+	for _, s := range open {
+		s.mu.Lock()
+		_ = s.end(false)
+		s.mu.Unlock()
+	}
+}
+
+// sessionHandler serves /session/{id}/query, /commit and /rollback for a
+// session opened with /session/begin. The transaction lives on its own
+// connection until it is committed, rolled back, or left idle for
+// SESSION_IDLE_TIMEOUT.
+func sessionHandler(w http.ResponseWriter, r *http.Request) {
+	id, action := r.PathValue("id"), r.PathValue("action")
+
+	sessionsMu.Lock()
+	s := sessions[id]
+	sessionsMu.Unlock()
+	// Sessions belong to the key that began them; anyone else is told the
+	// same as for an unknown ID.
+	if s == nil || s.key != apiKey(r) {
+		respondJSON(w, r, http.StatusNotFound, ErrorResponse{
+			Error:   "Session not found",
+			Message: "the session does not exist, has ended, or was rolled back after being idle",
+		})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		respondJSON(w, r, http.StatusNotFound, ErrorResponse{
+			Error:   "Session not found",
+			Message: "the session has ended",
+		})
+		return
+	}
+
+	switch action {
+	case "query":
+		s.idle.Reset(sessionIdleTimeout)
+		sessionQuery(w, r, s)
+	case "commit":
+		if err := s.end(true); err != nil {
+			respondErr(w, r, err)
+			return
+		}
+		respondJSON(w, r, http.StatusOK, StatusResponse{Status: "committed"})
+	case "rollback":
+		if err := s.end(false); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			respondErr(w, r, err)
+			return
+		}
+		respondJSON(w, r, http.StatusOK, StatusResponse{Status: "rolled back"})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func beginSession(w http.ResponseWriter, r *http.Request) {
+	place, ok := reserveSlot(&sessionsMu, &sessionsOpening, func() int { return len(sessions) }, maxSessions)
+	if !ok {
+		w.Header().Set("Retry-After", "1")
+		respondJSON(w, r, http.StatusServiceUnavailable, ErrorResponse{
+			Error:     "Too many sessions",
+			Message:   fmt.Sprintf("%d sessions are already open", maxSessions),
+			RequestID: requestID(r),
+		})
+		return
+	}
+	defer place.release()
+	if !reserveTransaction() {
+		respondTooManyTransactions(w, r)
+		return
+	}
+
+	conn, ok := acquireConn(w, r, db)
+	if !ok {
+		releaseTransaction()
+		return
+	}
+
+	// The transaction outlives this request, so it can't hang off its
+	// context; cancel is called when the session ends.
+	ctx, cancel := context.WithCancel(context.Background())
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		cancel()
+		_ = conn.Close()
+		releaseTransaction()
+		respondErr(w, r, err)
+		return
+	}
+
+	s := &session{id: newRequestID(), key: apiKey(r), conn: conn, tx: tx, cancel: cancel}
+	s.idle = time.AfterFunc(sessionIdleTimeout, s.expire)
+
+	if rlsMode != "" {
+		user, err := dbIdentityFor(s.key)
+		if err == nil {
+			err = applyDBIdentity(r.Context(), tx, user)
+		}
+		if err != nil {
+			_ = s.end(false)
+			if errors.Is(err, errNoDBIdentity) {
+				respondJSON(w, r, http.StatusForbidden, ErrorResponse{
+					Error:   "Forbidden",
+					Message: err.Error(),
+				})
+				return
+			}
+			respondErr(w, r, err)
+			return
+		}
+	}
+
+	sessionsMu.Lock()
+	sessions[s.id] = s
+	place.fill()
+	sessionsMu.Unlock()
+
+	respondJSON(w, r, http.StatusOK, SessionResponse{
+		SessionID:   s.id,
+		IdleTimeout: sessionIdleTimeout.String(),
+	})
+}
+
+// sessionQuery runs one statement in the session's transaction. A failed
+// statement leaves the session open; on Postgres the transaction is then
+// aborted and only /rollback is useful.
+func sessionQuery(w http.ResponseWriter, r *http.Request, s *session) {
+	var st TxStatement
+	if berr := decodeJSONBody(w, r, &st); berr != nil {
+		respondJSON(w, r, berr.status, ErrorResponse{
+			Error: berr.msg,
+		})
+		return
+	}
+	bound, ok := bindTxStatement(w, r, st, nil)
+	if !ok {
+		return
+	}
+
+	ctx, span := startDBSpan(r.Context(), bound.queryType, bound.sql)
+	defer span.End()
+	r = r.WithContext(ctx)
+
+	if queryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, queryTimeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+
+	result, err := runTxStatement(ctx, s.tx, tagQuery(r, bound.sql), bound.queryType, bound.args, rowLimitFor(s.key))
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+	respondJSON(w, r, http.StatusOK, result)
+}
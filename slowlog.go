@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// ---- SLOW QUERY LOG ----
+
+// slowExplainTimeout bounds the EXPLAIN run for a slow query.
+const slowExplainTimeout = 10 * time.Second
+
+// explaining is set while a slow query's EXPLAIN runs. Only one runs at a
+// time, so a burst of slow queries against a struggling database doesn't
+// add a burst of plans on top.
+var explaining atomic.Bool
+
+// logSlowQuery logs a statement that took SLOW_QUERY_THRESHOLD or longer
+// and, with SLOW_QUERY_EXPLAIN, its plan. The plan is fetched in the
+// background on its own connection; it only covers SELECTs, and the
+// EXPLAIN itself never passes through observeQuery, so it can't be logged
+// (or explained) in turn.
+func logSlowQuery(info *requestInfo, fingerprint string, elapsed time.Duration, status int) {
+	log.Printf("[%s] slow query: %s %s took %s (status %d, fingerprint %s): %s",
+		info.id, info.clientIP, info.queryType, elapsed.Round(time.Millisecond), status, fingerprint, loggedSQL(info.sql))
+
+	if !slowQueryExplain || (info.queryType != "SELECT" && info.queryType != "WITH") {
+		return
+	}
+	if !explaining.CompareAndSwap(false, true) {
+		debugf("[%s] slow query not explained: another EXPLAIN is running", info.id)
+		return
+	}
+	id, sqlQuery, args := info.id, info.sql, info.args
+	go func() {
+		defer explaining.Store(false)
+		ctx, cancel := context.WithTimeout(context.Background(), slowExplainTimeout)
+		defer cancel()
+
+		plan, err := explainPlan(ctx, sqlQuery, args)
+		if err != nil {
+			log.Printf("[%s] slow query EXPLAIN failed: %v", id, err)
+			return
+		}
+		log.Printf("[%s] slow query plan: %s", id, plan)
+	}()
+}
+
+// explainPlan returns sqlQuery's plan as one line of JSON: MySQL's EXPLAIN
+// FORMAT=JSON or Postgres' EXPLAIN (FORMAT JSON), without ANALYZE so the
+// statement isn't run again.
+func explainPlan(ctx context.Context, sqlQuery string, args []interface{}) (string, error) {
+	explain := "EXPLAIN FORMAT=JSON "
+	if dbDriver == driverPostgres {
+		explain = "EXPLAIN (FORMAT JSON) "
+	}
+	var raw string
+	if err := poolFor("SELECT").QueryRowContext(ctx, explain+sqlQuery, args...).Scan(&raw); err != nil {
+		return "", err
+	}
+	var plan bytes.Buffer
+	if err := json.Compact(&plan, []byte(raw)); err != nil {
+		return raw, nil
+	}
+	return plan.String(), nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ---- RESULT SORTING ----
+
+// SortKey orders a result by one column, "asc" (the default) or "desc".
+type SortKey struct {
+	Field string `json:"field"`
+	Dir   string `json:"dir,omitempty"`
+}
+
+// validateSort checks keys against the result's columns.
+func validateSort(keys []SortKey, cols []ColumnInfo) error {
+	have := make(map[string]bool, len(cols))
+	for _, c := range cols {
+		have[c.Name] = true
+	}
+	for _, k := range keys {
+		if !have[k.Field] {
+			return fmt.Errorf("unknown sort field %q", k.Field)
+		}
+		if d := strings.ToLower(k.Dir); d != "" && d != "asc" && d != "desc" {
+			return fmt.Errorf("sort dir for %s must be asc or desc", k.Field)
+		}
+	}
+	return nil
+}
+
+// sortRows orders rows in place by keys. The sort is stable, so rows that
+// tie keep the database's order.
+func sortRows(rows []map[string]interface{}, keys []SortKey) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		for _, k := range keys {
+			c := compareValues(rows[i][k.Field], rows[j][k.Field])
+			if c == 0 {
+				continue
+			}
+			if strings.EqualFold(k.Dir, "desc") {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+}
+
+// compareValues orders two result values. Numeric strings, which is how
+// MySQL's text protocol returns numbers and decimals, compare as numbers.
+// Values of different kinds order by kind: null, then booleans, numbers,
+// times, strings and anything else, so nulls come first ascending and last
+// descending, as in MySQL. Typed cells compare by their value.
+func compareValues(a, b interface{}) int {
+	if c, ok := a.(typedCell); ok {
+		a = c.V
+	}
+	if c, ok := b.(typedCell); ok {
+		b = c.V
+	}
+	ka, kb := valueKind(a), valueKind(b)
+	if ka != kb {
+		return ka - kb
+	}
+
+	switch ka {
+	case kindNull:
+		return 0
+	case kindBool:
+		x, y := a.(bool), b.(bool)
+		switch {
+		case x == y:
+			return 0
+		case !x:
+			return -1
+		}
+		return 1
+	case kindNumber:
+		x, y := toFloat(a), toFloat(b)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	case kindTime:
+		return a.(time.Time).Compare(b.(time.Time))
+	case kindString:
+		return strings.Compare(a.(string), b.(string))
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+const (
+	kindNull = iota
+	kindBool
+	kindNumber
+	kindTime
+	kindString
+	kindOther
+)
+
+func valueKind(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return kindNull
+	case bool:
+		return kindBool
+	case int64, int32, int, uint64, float64, float32, json.Number:
+		return kindNumber
+	case time.Time:
+		return kindTime
+	case string:
+		if _, err := strconv.ParseFloat(v.(string), 64); err == nil {
+			return kindNumber
+		}
+		return kindString
+	}
+	return kindOther
+}
+
+func toFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case int64:
+		return float64(n)
+	case int32:
+		return float64(n)
+	case int:
+		return float64(n)
+	case uint64:
+		return float64(n)
+	case float64:
+		return n
+	case float32:
+		return float64(n)
+	case json.Number:
+		f, _ := n.Float64()
+		return f
+	case string:
+		f, _ := strconv.ParseFloat(n, 64)
+		return f
+	}
+	return 0
+}
@@ -0,0 +1,611 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ---- SQL SCANNING ----
+
+// The tokenizer below is deliberately small: it knows enough about
+// comments, quoting and dialect differences to find keywords, parentheses
+// and placeholders reliably, not to parse SQL. Concatenating the text of
+// all tokens reproduces the input exactly, so callers can rewrite a
+// statement token by token.
+
+type tokenKind int
+
+const (
+	tokSpace tokenKind = iota
+	tokComment
+	tokWord        // unquoted identifier or keyword
+	tokQuotedIdent // `name` (and "name" on Postgres)
+	tokString      // '...' (and "..." on MySQL), $$...$$ on Postgres
+	tokNumber
+	tokPlaceholder // ? or $n
+	tokPunct       // any other single character
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+// is reports whether t is the keyword kw, case-insensitively.
+func (t token) is(kw string) bool {
+	return t.kind == tokWord && strings.EqualFold(t.text, kw)
+}
+
+func tokenize(sql string) []token {
+	var tokens []token
+	pg := dbDriver == driverPostgres
+
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		start := i
+
+		switch {
+		case isSpace(c):
+			for i < len(sql) && isSpace(sql[i]) {
+				i++
+			}
+			tokens = append(tokens, token{tokSpace, sql[start:i]})
+
+		case c == '-' && strings.HasPrefix(sql[i:], "--") && (pg || i+2 == len(sql) || isSpace(sql[i+2])),
+			c == '#' && !pg:
+			for i < len(sql) && sql[i] != '\n' {
+				i++
+			}
+			tokens = append(tokens, token{tokComment, sql[start:i]})
+
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				i = len(sql)
+			} else {
+				i += end + 4
+			}
+			tokens = append(tokens, token{tokComment, sql[start:i]})
+
+		case c == '\'' || (c == '"' && !pg):
+			i = scanQuoted(sql, i, c, !pg)
+			tokens = append(tokens, token{tokString, sql[start:i]})
+
+		case c == '`' || (c == '"' && pg):
+			i = scanQuoted(sql, i, c, false)
+			tokens = append(tokens, token{tokQuotedIdent, sql[start:i]})
+
+		case c == '$' && pg && i+1 < len(sql) && isDigit(sql[i+1]):
+			i++
+			for i < len(sql) && isDigit(sql[i]) {
+				i++
+			}
+			tokens = append(tokens, token{tokPlaceholder, sql[start:i]})
+
+		case c == '$' && pg:
+			if end, ok := scanDollarQuoted(sql, i); ok {
+				i = end
+				tokens = append(tokens, token{tokString, sql[start:i]})
+			} else {
+				i++
+				tokens = append(tokens, token{tokPunct, sql[start:i]})
+			}
+
+		case c == '?':
+			i++
+			tokens = append(tokens, token{tokPlaceholder, "?"})
+
+		case isDigit(c):
+			for i < len(sql) && (isDigit(sql[i]) || sql[i] == '.' || isWordChar(sql[i])) {
+				i++
+			}
+			tokens = append(tokens, token{tokNumber, sql[start:i]})
+
+		case isWordChar(c):
+			for i < len(sql) && (isWordChar(sql[i]) || isDigit(sql[i]) || sql[i] == '$') {
+				i++
+			}
+			tokens = append(tokens, token{tokWord, sql[start:i]})
+
+		default:
+			i++
+			tokens = append(tokens, token{tokPunct, sql[start:i]})
+		}
+	}
+	return tokens
+}
+
+// scanQuoted returns the index just past the quoted run starting at i.
+// A doubled quote is an escaped quote; backslash escapes apply to MySQL
+// strings.
+func scanQuoted(sql string, i int, quote byte, backslash bool) int {
+	for i++; i < len(sql); i++ {
+		switch sql[i] {
+		case '\\':
+			if backslash {
+				i++
+			}
+		case quote:
+			if i+1 < len(sql) && sql[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(sql)
+}
+
+// scanDollarQuoted handles Postgres $tag$...$tag$ strings.
+func scanDollarQuoted(sql string, i int) (int, bool) {
+	end := strings.IndexByte(sql[i+1:], '$')
+	if end < 0 {
+		return 0, false
+	}
+	tag := sql[i : i+end+2]
+	for _, c := range []byte(tag[1 : len(tag)-1]) {
+		if !isWordChar(c) && !isDigit(c) {
+			return 0, false
+		}
+	}
+	close := strings.Index(sql[i+len(tag):], tag)
+	if close < 0 {
+		return len(sql), true
+	}
+	return i + len(tag) + close + len(tag), true
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+// significant drops whitespace and comments.
+func significant(tokens []token) []token {
+	out := tokens[:0:0]
+	for _, t := range tokens {
+		if t.kind != tokSpace && t.kind != tokComment {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// hasTopLevelKeyword reports whether kw appears outside any parentheses,
+// ignoring comments and literals.
+func hasTopLevelKeyword(sql, kw string) bool {
+	depth := 0
+	for _, t := range significant(tokenize(sql)) {
+		switch {
+		case t.text == "(":
+			depth++
+		case t.text == ")":
+			depth--
+		case depth == 0 && t.is(kw):
+			return true
+		}
+	}
+	return false
+}
+
+// normalizePlaceholders rewrites ? placeholders to Postgres' $1, $2, ...
+// when NORMALIZE_PLACEHOLDERS is on, skipping literals and comments. Note
+// that this claims ?, so jsonb's ? / ?| / ?& operators can't be used then.
+func normalizePlaceholders(sql string) string {
+	if !normalizePlaceholdersOn || dbDriver != driverPostgres || !strings.Contains(sql, "?") {
+		return sql
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, t := range tokenize(sql) {
+		if t.kind == tokPlaceholder && t.text == "?" {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteString(t.text)
+	}
+	return b.String()
+}
+
+// fromListEnd are the keywords that close a comma-separated FROM (or
+// multi-table UPDATE) list.
+var fromListEnd = map[string]bool{
+	"WHERE": true, "ON": true, "USING": true, "GROUP": true, "ORDER": true,
+	"HAVING": true, "LIMIT": true, "OFFSET": true, "FETCH": true, "WINDOW": true,
+	"UNION": true, "EXCEPT": true, "INTERSECT": true, "SET": true, "FOR": true,
+	"RETURNING": true, "INTO": true, "LOCK": true,
+}
+
+// referencedTables extracts the tables a statement names after FROM, JOIN,
+// INTO, UPDATE, TABLE and VIEW (and in comma lists following FROM/UPDATE,
+// including parenthesised ones such as FROM (a JOIN b)), the tables of
+// TRUNCATE, LOCK, RENAME, DESCRIBE, EXPLAIN <table>, HANDLER, SHOW ... IN
+// and CREATE/DROP INDEX ... ON, lower-cased and unquoted, as "name" or
+// "schema.name". Common table expression names are left out where they
+// refer to the CTE (see cteScopes), and FROM inside function calls
+// (EXTRACT(... FROM x)) is ignored. Like the rest of this file it is a
+// heuristic, not a parser: when unsure it reports a name, and
+// statementShapeKnown tells which statements it understands at all, so
+// authorization built on both fails closed.
+func referencedTables(sql string) []string {
+	toks := significant(tokenize(sql))
+
+	ctes := cteScopes(toks)
+
+	var lead token
+	if len(toks) > 0 {
+		lead = toks[0]
+	}
+	ddl := lead.is("CREATE") || lead.is("ALTER") || lead.is("DROP")
+	indexOn := (lead.is("CREATE") || lead.is("DROP")) && hasTopLevelKeyword(sql, "INDEX")
+	createLike := lead.is("CREATE") && !hasTopLevelKeyword(sql, "SELECT")
+
+	var tables []string
+	seen := map[string]bool{}
+
+	// One frame per open parenthesis, plus the statement itself.
+	type frame struct {
+		subquery   bool // the parenthesis holds a SELECT
+		fromList   bool // inside a FROM/UPDATE table list
+		tableGroup bool // the parenthesis groups tables: FROM (a JOIN b)
+	}
+	frames := []frame{{subquery: true}}
+
+	for i := 0; i < len(toks); i++ {
+		t := toks[i]
+		top := &frames[len(frames)-1]
+		var prev token
+		if i > 0 {
+			prev = toks[i-1]
+		}
+
+		switch {
+		case t.text == "(":
+			sub := i+1 < len(toks) && (toks[i+1].is("SELECT") || toks[i+1].is("WITH"))
+			tableSlot := prev.is("FROM") || prev.is("JOIN") ||
+				(prev.text == "," && top.fromList) || (prev.text == "(" && top.tableGroup)
+			if sub || !tableSlot || !top.subquery {
+				frames = append(frames, frame{subquery: sub})
+				continue
+			}
+			frames = append(frames, frame{subquery: true, fromList: true, tableGroup: true})
+		case t.text == ")":
+			if len(frames) > 1 {
+				frames = frames[:len(frames)-1]
+			}
+			continue
+		case t.is("FROM"):
+			if !top.subquery || prev.is("DISTINCT") {
+				continue // EXTRACT(YEAR FROM d), IS DISTINCT FROM, ...
+			}
+			top.fromList = true
+		case t.is("UPDATE"):
+			if prev.is("FOR") || prev.is("KEY") {
+				continue // SELECT ... FOR UPDATE, ON DUPLICATE KEY UPDATE
+			}
+			top.fromList = true
+		case i == 0 && (t.is("TRUNCATE") || t.is("LOCK") || t.is("RENAME")):
+			top.fromList = true
+		case i == 0 && t.is("HANDLER"):
+		case i == 0 && (t.is("EXPLAIN") || t.is("DESCRIBE") || t.is("DESC")):
+			if i+1 < len(toks) && (!isIdent(toks[i+1]) || explainWords[strings.ToUpper(toks[i+1].text)]) {
+				continue // EXPLAIN SELECT ..., EXPLAIN (FORMAT JSON) ...
+			}
+		case t.is("ON") && indexOn:
+			indexOn = false // only the first: the indexed table
+		case t.is("IN") && lead.is("SHOW"):
+		case t.is("TO") && lead.is("RENAME"):
+		case t.is("RENAME") && lead.is("ALTER"):
+			if i+1 < len(toks) && (toks[i+1].is("COLUMN") || toks[i+1].is("INDEX") ||
+				toks[i+1].is("KEY") || toks[i+1].is("CONSTRAINT")) {
+				continue
+			}
+		case t.is("LIKE") && createLike:
+		case t.is("VIEW") && ddl, t.is("REFERENCES"):
+		case t.is("JOIN"), t.is("INTO"), t.is("TABLE"):
+		case t.text == "," && top.fromList:
+		default:
+			if t.kind == tokWord && fromListEnd[strings.ToUpper(t.text)] {
+				top.fromList = false
+			}
+			continue
+		}
+
+		j := i + 1
+		if t.is("RENAME") && j < len(toks) && (toks[j].is("TO") || toks[j].is("AS")) {
+			j++
+		}
+		for j < len(toks) && (toks[j].is("ONLY") || toks[j].is("LATERAL") || toks[j].is("TABLE") ||
+			toks[j].is("TABLES") || toks[j].is("IF") || toks[j].is("NOT") || toks[j].is("EXISTS")) {
+			j++
+		}
+		if name, next := readTableName(toks, j); name != "" {
+			if !ctes.covers(name, j) && !seen[name] {
+				seen[name] = true
+				tables = append(tables, name)
+			}
+			i = next - 1
+		}
+	}
+	return tables
+}
+
+// cteNames maps each common table expression name to the token ranges
+// where it means the CTE rather than a table of that name.
+type cteNames map[string][][2]int
+
+func (c cteNames) covers(name string, at int) bool {
+	for _, span := range c[name] {
+		if at >= span[0] && at < span[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// cteScopes finds each "name AS (...)" and where it is in scope: from the
+// end of its body (or, under WITH RECURSIVE, its start) to the end of the
+// query that defines it. Inside a plain CTE's own body, and outside the
+// subquery it was defined in, the name is an ordinary table.
+func cteScopes(toks []token) cteNames {
+	closing := make([]int, len(toks))
+	var open []int
+	for i, t := range toks {
+		closing[i] = len(toks)
+		switch t.text {
+		case "(":
+			open = append(open, i)
+		case ")":
+			if len(open) > 0 {
+				closing[open[len(open)-1]] = i
+				open = open[:len(open)-1]
+			}
+		}
+	}
+
+	ctes := cteNames{}
+	recursive := map[int]bool{} // by the parenthesis enclosing the WITH
+	open = open[:0]
+	for i, t := range toks {
+		enclosing := -1
+		if len(open) > 0 {
+			enclosing = open[len(open)-1]
+		}
+		switch {
+		case t.text == "(":
+			open = append(open, i)
+		case t.text == ")":
+			if len(open) > 0 {
+				open = open[:len(open)-1]
+			}
+		case t.is("WITH") && i+1 < len(toks) && toks[i+1].is("RECURSIVE"):
+			recursive[enclosing] = true
+		case isIdent(t) && i+2 < len(toks) && toks[i+1].is("AS") && toks[i+2].text == "(":
+			end := len(toks)
+			if enclosing >= 0 {
+				end = closing[enclosing]
+			}
+			start := closing[i+2]
+			if recursive[enclosing] {
+				start = i + 2
+			}
+			name := identName(t)
+			ctes[name] = append(ctes[name], [2]int{start, end})
+		}
+	}
+	return ctes
+}
+
+// explainWords are what EXPLAIN (or DESCRIBE) may be followed by other
+// than a table.
+var explainWords = map[string]bool{
+	"SELECT": true, "WITH": true, "INSERT": true, "REPLACE": true, "UPDATE": true,
+	"DELETE": true, "TABLE": true, "VALUES": true, "ANALYZE": true, "FORMAT": true,
+	"EXTENDED": true, "PARTITIONS": true, "VERBOSE": true, "FOR": true,
+}
+
+// statementShapeKnown reports whether referencedTables understands the
+// kind of statement sql is, so an empty result means it names no tables
+// rather than that they went unseen. Table allowlists reject the rest.
+func statementShapeKnown(sql string) bool {
+	toks := significant(tokenize(sql))
+	for len(toks) > 0 && toks[0].text == "(" {
+		toks = toks[1:] // (SELECT ...) UNION (SELECT ...)
+	}
+	if len(toks) == 0 {
+		return false
+	}
+	switch strings.ToUpper(toks[0].text) {
+	case "SELECT", "WITH", "INSERT", "REPLACE", "UPDATE", "DELETE", "TABLE", "VALUES",
+		"SHOW", "DESCRIBE", "DESC", "EXPLAIN", "TRUNCATE", "LOCK", "HANDLER", "RENAME",
+		"SET", "BEGIN", "START", "COMMIT", "ROLLBACK", "SAVEPOINT", "RELEASE":
+		return toks[0].kind == tokWord
+	case "CREATE", "ALTER", "DROP":
+		for _, t := range toks[1:] {
+			switch {
+			case t.is("TABLE"), t.is("VIEW"):
+				return true
+			case t.is("INDEX"):
+				return hasTopLevelKeyword(sql, "ON")
+			case t.is("OR"), t.is("REPLACE"), t.is("GLOBAL"), t.is("LOCAL"), t.is("TEMPORARY"),
+				t.is("TEMP"), t.is("UNLOGGED"), t.is("UNIQUE"), t.is("ONLINE"), t.is("OFFLINE"):
+			default:
+				return false
+			}
+		}
+	}
+	return false
+}
+
+// readTableName reads a possibly schema-qualified name starting at i and
+// returns it with the index just past it, or "" if there is none.
+func readTableName(toks []token, i int) (string, int) {
+	if i >= len(toks) || !isIdent(toks[i]) {
+		return "", i
+	}
+	name := identName(toks[i])
+	i++
+	for i+1 < len(toks) && toks[i].text == "." && isIdent(toks[i+1]) {
+		name += "." + identName(toks[i+1])
+		i += 2
+	}
+	return name, i
+}
+
+func isIdent(t token) bool {
+	return t.kind == tokWord || t.kind == tokQuotedIdent
+}
+
+// identName lower-cases an identifier, removing quotes.
+func identName(t token) string {
+	return strings.ToLower(unquoteIdent(t))
+}
+
+func unquoteIdent(t token) string {
+	if t.kind != tokQuotedIdent {
+		return t.text
+	}
+	q := t.text[:1]
+	return strings.ReplaceAll(t.text[1:len(t.text)-1], q+q, q)
+}
+
+// quoteIdent quotes a column or table name for the configured dialect.
+func quoteIdent(name string) string {
+	if dbDriver == driverPostgres {
+		return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+	}
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// trimStatement strips surrounding whitespace and a single trailing
+// semicolon, along with any comments after it, so statements pasted from
+// an editor route the same with or without one.
+func trimStatement(sql string) string {
+	tokens := tokenize(sql)
+	end := len(tokens)
+	for end > 0 && (tokens[end-1].kind == tokSpace || tokens[end-1].kind == tokComment) {
+		end--
+	}
+	if end > 0 && tokens[end-1].kind == tokPunct && tokens[end-1].text == ";" {
+		sql = tokensText(tokens[:end-1])
+	}
+	return strings.TrimSpace(sql)
+}
+
+// hasMultipleStatements reports whether a (trimmed) statement contains a
+// semicolon outside literals and comments. The BEGIN ... END body of a
+// CREATE PROCEDURE, FUNCTION, TRIGGER or EVENT separates statements with
+// semicolons but is sent as one, so semicolons inside it don't count; one
+// before the body or after its END does.
+func hasMultipleStatements(sql string) bool {
+	toks := significant(tokenize(sql))
+	routine := isRoutineDefinition(toks)
+	depth := 0
+	for i := 0; i < len(toks); i++ {
+		t := toks[i]
+		switch {
+		case !routine:
+		case t.is("BEGIN"), t.is("CASE"):
+			depth++
+		case t.is("END"):
+			next := token{}
+			if i+1 < len(toks) {
+				next = toks[i+1]
+			}
+			switch {
+			case next.is("IF"), next.is("LOOP"), next.is("WHILE"), next.is("REPEAT"):
+				i++
+			case next.is("CASE"):
+				i++
+				depth--
+			default:
+				depth--
+			}
+		}
+		if t.kind == tokPunct && t.text == ";" && depth <= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// statementType returns a statement's first keyword, upper-cased, past any
+// comments and the opening parentheses of (SELECT ...) UNION ..., so
+// "/**/DELETE/**/FROM t" is a DELETE. It returns "" for a statement with
+// no tokens.
+func statementType(sql string) string {
+	toks := significant(tokenize(sql))
+	for len(toks) > 1 && toks[0].text == "(" {
+		toks = toks[1:]
+	}
+	if len(toks) == 0 {
+		return ""
+	}
+	return strings.ToUpper(toks[0].text)
+}
+
+// unguardedWrite returns "UPDATE" or "DELETE" when sql is one, directly or
+// after a WITH clause, with no top-level WHERE, and "" otherwise.
+func unguardedWrite(sql string) string {
+	switch statementType(sql) {
+	case "UPDATE", "DELETE", "WITH":
+	default:
+		return ""
+	}
+	depth, verb := 0, ""
+	for _, t := range significant(tokenize(sql)) {
+		switch {
+		case t.text == "(":
+			depth++
+		case t.text == ")":
+			depth--
+		case depth != 0:
+		case verb == "" && (t.is("UPDATE") || t.is("DELETE")):
+			verb = strings.ToUpper(t.text)
+		case verb == "" && (t.is("SELECT") || t.is("INSERT") || t.is("REPLACE")):
+			return ""
+		case verb != "" && t.is("WHERE"):
+			return ""
+		}
+	}
+	return verb
+}
+
+// isRoutineDefinition reports whether toks start CREATE [OR REPLACE]
+// [DEFINER = user] [AGGREGATE] PROCEDURE, FUNCTION, TRIGGER or EVENT.
+func isRoutineDefinition(toks []token) bool {
+	if len(toks) == 0 || !toks[0].is("CREATE") {
+		return false
+	}
+	i := 1
+	if i+1 < len(toks) && toks[i].is("OR") && toks[i+1].is("REPLACE") {
+		i += 2
+	}
+	if i < len(toks) && toks[i].is("DEFINER") {
+		i++
+		if i < len(toks) && toks[i].text == "=" {
+			i++
+		}
+		i++ // the user, or CURRENT_USER
+		switch {
+		case i+1 < len(toks) && toks[i].text == "@":
+			i += 2
+		case i+1 < len(toks) && toks[i].text == "(" && toks[i+1].text == ")":
+			i += 2
+		}
+	}
+	if i < len(toks) && toks[i].is("AGGREGATE") {
+		i++
+	}
+	return i < len(toks) && (toks[i].is("PROCEDURE") || toks[i].is("FUNCTION") ||
+		toks[i].is("TRIGGER") || toks[i].is("EVENT"))
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReferencedTables(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want []string
+	}{
+		{"SELECT * FROM a", []string{"a"}},
+		{"SELECT * FROM a, b JOIN c ON 1", []string{"a", "b", "c"}},
+		{"SELECT * FROM shop.orders o", []string{"shop.orders"}},
+		{"SELECT * FROM `Shop`.`Orders`", []string{"shop.orders"}},
+		{"SELECT * FROM (secret)", []string{"secret"}},
+		{"SELECT * FROM ((secret))", []string{"secret"}},
+		{"SELECT * FROM a JOIN (secret) ON 1", []string{"a", "secret"}},
+		{"SELECT * FROM (a JOIN secret ON 1)", []string{"a", "secret"}},
+		{"SELECT * FROM (a, secret)", []string{"a", "secret"}},
+		{"SELECT * FROM a, (secret)", []string{"a", "secret"}},
+		{"SELECT * FROM (SELECT * FROM secret) s", []string{"secret"}},
+		{"SELECT EXTRACT(YEAR FROM d) FROM a", []string{"a"}},
+		{"SELECT * FROM a WHERE x IN (1, 2)", []string{"a"}},
+		{"SELECT * FROM a FOR UPDATE", []string{"a"}},
+		{"WITH x AS (SELECT * FROM secret) SELECT * FROM x", []string{"secret"}},
+		{"WITH secret AS (SELECT * FROM secret) SELECT * FROM secret", []string{"secret"}},
+		{"WITH a AS (SELECT 1), b AS (SELECT * FROM a) SELECT * FROM b", nil},
+		{"WITH a AS (SELECT * FROM b), b AS (SELECT 1) SELECT * FROM a", []string{"b"}},
+		{"WITH RECURSIVE r AS (SELECT 1 UNION ALL SELECT n + 1 FROM r) SELECT * FROM r", nil},
+		{"SELECT * FROM (WITH secret AS (SELECT 1) SELECT * FROM secret) x JOIN secret ON 1", []string{"secret"}},
+		{"INSERT INTO a (x, y) VALUES (1, 2)", []string{"a"}},
+		{"INSERT INTO a SELECT * FROM secret", []string{"a", "secret"}},
+		{"UPDATE a, secret SET a.x = 1", []string{"a", "secret"}},
+		{"DELETE FROM a WHERE id IN (SELECT id FROM secret)", []string{"a", "secret"}},
+		{"TABLE secret", []string{"secret"}},
+		{"TRUNCATE secret", []string{"secret"}},
+		{"TRUNCATE TABLE secret", []string{"secret"}},
+		{"TRUNCATE a, secret", []string{"a", "secret"}},
+		{"DESCRIBE secret", []string{"secret"}},
+		{"DESC secret", []string{"secret"}},
+		{"EXPLAIN secret", []string{"secret"}},
+		{"EXPLAIN SELECT * FROM secret", []string{"secret"}},
+		{"EXPLAIN FORMAT=JSON SELECT * FROM secret", []string{"secret"}},
+		{"LOCK TABLES a READ, secret WRITE", []string{"a", "secret"}},
+		{"LOCK TABLE secret IN ACCESS EXCLUSIVE MODE", []string{"secret"}},
+		{"HANDLER secret OPEN", []string{"secret"}},
+		{"SHOW COLUMNS IN secret", []string{"secret"}},
+		{"SHOW COLUMNS FROM secret", []string{"secret"}},
+		{"CREATE INDEX i ON secret (x)", []string{"secret"}},
+		{"CREATE UNIQUE INDEX i ON secret (x)", []string{"secret"}},
+		{"DROP INDEX i ON secret", []string{"secret"}},
+		{"CREATE TABLE a LIKE secret", []string{"a", "secret"}},
+		{"CREATE TABLE a (id int REFERENCES secret (id))", []string{"a", "secret"}},
+		{"CREATE VIEW v AS SELECT * FROM secret", []string{"v", "secret"}},
+		{"RENAME TABLE a TO b, c TO d", []string{"a", "b", "c", "d"}},
+		{"ALTER TABLE a RENAME TO secret", []string{"a", "secret"}},
+		{"ALTER TABLE a RENAME COLUMN x TO y", []string{"a"}},
+		{"SELECT 'FROM secret' -- FROM secret", nil},
+	}
+	for _, tt := range tests {
+		if got := referencedTables(tt.sql); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("referencedTables(%q) = %q, want %q", tt.sql, got, tt.want)
+		}
+	}
+}
+
+func TestStatementShapeKnown(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want bool
+	}{
+		{"SELECT 1", true},
+		{"(SELECT 1) UNION (SELECT 2)", true},
+		{"WITH x AS (SELECT 1) SELECT * FROM x", true},
+		{"TRUNCATE secret", true},
+		{"DESCRIBE secret", true},
+		{"SET @x = 1", true},
+		{"CREATE TABLE a (id int)", true},
+		{"CREATE OR REPLACE VIEW v AS SELECT 1", true},
+		{"CREATE INDEX i ON a (x)", true},
+		{"DROP INDEX i", false},
+		{"CREATE TRIGGER t BEFORE INSERT ON secret FOR EACH ROW SET @x = 1", false},
+		{"CALL read_secret()", false},
+		{"COPY secret TO STDOUT", false},
+		{"PREPARE s FROM 'SELECT * FROM secret'", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := statementShapeKnown(tt.sql); got != tt.want {
+			t.Errorf("statementShapeKnown(%q) = %v, want %v", tt.sql, got, tt.want)
+		}
+	}
+}
+
+func TestHasMultipleStatements(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want bool
+	}{
+		{"SELECT 1", false},
+		{"SELECT ';' FROM a -- ;", false},
+		{"SELECT 1; SELECT 2", true},
+		{"SELECT 1;", true},
+		{"CREATE TABLE t (x int); BEGIN; DROP TABLE u", true},
+		{"CREATE TABLE t (x int); DROP TABLE u", true},
+		{"CREATE VIEW v AS SELECT 1; BEGIN DROP TABLE u; END", true},
+		{"CREATE PROCEDURE p() BEGIN SELECT 1; SELECT 2; END", false},
+		{"CREATE DEFINER = `root`@`localhost` PROCEDURE p() BEGIN SELECT 1; END", false},
+		{"CREATE DEFINER = CURRENT_USER() FUNCTION f() RETURNS int BEGIN RETURN 1; END", false},
+		{"CREATE OR REPLACE AGGREGATE FUNCTION f(x int) RETURNS int BEGIN RETURN x; END", false},
+		{"CREATE TRIGGER t BEFORE INSERT ON a FOR EACH ROW BEGIN SET @x = 1; END", false},
+		{"CREATE EVENT e ON SCHEDULE EVERY 1 DAY DO BEGIN DELETE FROM a; END", false},
+		{"CREATE PROCEDURE p() BEGIN IF 1 THEN SELECT 1; END IF; CASE WHEN 1 THEN SELECT 2; END CASE; END", false},
+		{"CREATE PROCEDURE p() BEGIN SELECT CASE WHEN 1 THEN 2 END; END", false},
+		{"CREATE PROCEDURE p() SELECT 1; DROP TABLE u", true},
+		{"CREATE PROCEDURE p() BEGIN SELECT 1; END; DROP TABLE u", true},
+		{"CREATE PROCEDURE p() BEGIN IF 1 THEN SELECT 1; END IF; END; DROP TABLE u", true},
+	}
+	for _, tt := range tests {
+		if got := hasMultipleStatements(tt.sql); got != tt.want {
+			t.Errorf("hasMultipleStatements(%q) = %v, want %v", tt.sql, got, tt.want)
+		}
+	}
+}
+
+func TestStatementType(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"select 1", "SELECT"},
+		{"DELETE/**/FROM t", "DELETE"},
+		{"/**/DELETE FROM t", "DELETE"},
+		{"-- note\nUPDATE t SET x = 1", "UPDATE"},
+		{"((SELECT 1)) UNION (SELECT 2)", "SELECT"},
+		{"WITH x AS (SELECT 1) DELETE FROM t", "WITH"},
+		{"/* only a comment */", ""},
+	}
+	for _, tt := range tests {
+		if got := statementType(tt.sql); got != tt.want {
+			t.Errorf("statementType(%q) = %q, want %q", tt.sql, got, tt.want)
+		}
+	}
+}
+
+func TestUnguardedWrite(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"DELETE FROM t", "DELETE"},
+		{"DELETE/**/FROM t", "DELETE"},
+		{"/**/DELETE FROM t", "DELETE"},
+		{"update t set x = 1", "UPDATE"},
+		{"UPDATE t SET x = (SELECT y FROM u WHERE u.id = 1)", "UPDATE"},
+		{"DELETE FROM t WHERE id IN (SELECT id FROM u) OR 1", ""},
+		{"WITH x AS (SELECT id FROM u WHERE 1) DELETE FROM t", "DELETE"},
+		{"WITH x AS (SELECT id FROM u) DELETE FROM t WHERE id IN (SELECT id FROM x)", ""},
+		{"WITH x AS (SELECT 1) SELECT * FROM x", ""},
+		{"DELETE FROM t WHERE id = 1", ""},
+		{"DELETE FROM t -- WHERE id = 1", "DELETE"},
+		{"DELETE FROM t WHERE note = 'no where'", ""},
+		{"SELECT * FROM t FOR UPDATE", ""},
+		{"INSERT INTO t VALUES (1) ON DUPLICATE KEY UPDATE x = 1", ""},
+		{"CREATE TRIGGER g BEFORE UPDATE ON t FOR EACH ROW DELETE FROM u", ""},
+	}
+	for _, tt := range tests {
+		if got := unguardedWrite(tt.sql); got != tt.want {
+			t.Errorf("unguardedWrite(%q) = %q, want %q", tt.sql, got, tt.want)
+		}
+	}
+}
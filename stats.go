@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"strconv"
+)
+
+// ---- EXECUTION STATS ----
+
+// handlerReads sums MySQL's session Handler_read_* counters, the storage
+// engine row reads so far on this connection. The delta around a query is
+// the number of rows it examined; SHOW STATUS itself adds a few reads, so
+// small counts are approximate.
+func handlerReads(ctx context.Context, q queryer) (int64, error) {
+	rows, err := q.QueryContext(ctx, "SHOW SESSION STATUS LIKE 'Handler_read%'")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var total int64
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return 0, err
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		total += n
+	}
+	return total, rows.Err()
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"reflect"
+)
+
+// ---- STREAMING JSON ----
+
+const streamFlushEvery = 1000
+
+// writeJSONStream encodes a SELECT result row by row instead of building
+// it in memory. Both drivers already read rows off the wire as they are
+// scanned, so memory stays bounded by one row; the price is that the
+// connection is held until the client has consumed the whole body.
+//
+// The envelope matches SelectResponse minus warnings, meta and column
+// notes, which are only known once every row has been read. Hitting the
+// row limit or MAX_RESPONSE_BYTES ends the rows with "truncated": true.
+// Errors after the first byte can only be logged; the client sees
+// truncated JSON.
+func writeJSONStream(w http.ResponseWriter, r *http.Request, rows *sql.Rows, scanner *rowScanner, queryType string, rowLimit int) int {
+	var columns interface{} = scanner.columnInfo()
+	if responseNaming != namingCamel {
+		columns = applyNaming(reflect.ValueOf(columns))
+	}
+	head, err := json.Marshal(columns)
+	if err != nil {
+		respondErr(w, r, err)
+		return 0
+	}
+	typ, _ := json.Marshal(queryType)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString(`{"type":`)
+	bw.Write(typ)
+	bw.WriteString(`,"columns":`)
+	bw.Write(head)
+	bw.WriteString(`,"rows":[`)
+
+	count := 0
+	truncated := false
+	var sent int64
+	for rows.Next() {
+		if rowLimit > 0 && count >= rowLimit {
+			truncated = true
+			break
+		}
+
+		row, err := scanner.scan(rows)
+		var b []byte
+		if err == nil {
+			b, err = json.Marshal(row)
+		}
+		if err != nil {
+			log.Printf("[%s] streamed result aborted after %d rows: %v", requestID(r), count, err)
+			bw.Flush()
+			return count
+		}
+		if overByteLimit(sent + int64(len(b))) {
+			truncated = true
+			break
+		}
+
+		if count > 0 {
+			bw.WriteByte(',')
+		}
+		bw.Write(b)
+		sent += int64(len(b))
+
+		count++
+		if count%streamFlushEvery == 0 {
+			bw.Flush()
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("[%s] streamed result aborted after %d rows: %v", requestID(r), count, err)
+		bw.Flush()
+		return count
+	}
+
+	tail, _ := json.Marshal(struct {
+		Count          int  `json:"count"`
+		Truncated      bool `json:"truncated"`
+		TruncatedCells bool `json:"truncatedCells,omitempty"`
+	}{count, truncated, scanner.truncatedCells})
+	bw.WriteString(`],`)
+	bw.Write(tail[1:])
+	bw.WriteByte('\n')
+	bw.Flush()
+	return count
+}
+
+// ---- NDJSON ----
+
+// NDJSONRowError is the line written in place of a row that could not be
+// scanned or encoded.
+type NDJSONRowError struct {
+	Error struct {
+		Row     int    `json:"row"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// NDJSONSummary is the last line of an NDJSON result.
+type NDJSONSummary struct {
+	Summary struct {
+		Rows      int    `json:"rows"`
+		Errors    int    `json:"errors"`
+		Truncated bool   `json:"truncated"`
+		Error     string `json:"error,omitempty"`
+
+		TruncatedCells bool `json:"truncatedCells,omitempty"`
+	} `json:"summary"`
+}
+
+// writeNDJSON streams a SELECT result (?format=ndjson) as one JSON object
+// per line, flushed as it goes. A row that fails to scan or encode is
+// replaced by an {"error": ...} line naming its position and the stream
+// carries on, so one bad value on messy data doesn't lose the rest of an
+// export. The last line is a {"summary": ...} with the row and error
+// counts; a stream without one was cut off.
+func writeNDJSON(w http.ResponseWriter, r *http.Request, rows *sql.Rows, scanner *rowScanner, rowLimit int) int {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+
+	bw := bufio.NewWriter(w)
+	writeLine := func(b []byte) {
+		bw.Write(b)
+		bw.WriteByte('\n')
+		bw.Flush()
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	var summary NDJSONSummary
+	var sent int64
+	index := 0
+	for rows.Next() {
+		if rowLimit > 0 && index >= rowLimit {
+			summary.Summary.Truncated = true
+			break
+		}
+
+		row, err := scanner.scan(rows)
+		var b []byte
+		if err == nil {
+			b, err = json.Marshal(row)
+		}
+		if err != nil {
+			var rowErr NDJSONRowError
+			rowErr.Error.Row, rowErr.Error.Message = index, err.Error()
+			b, _ = json.Marshal(rowErr)
+			summary.Summary.Errors++
+		} else {
+			summary.Summary.Rows++
+		}
+		if overByteLimit(sent + int64(len(b))) {
+			summary.Summary.Truncated = true
+			break
+		}
+		writeLine(b)
+		sent += int64(len(b))
+		index++
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("[%s] NDJSON result aborted after %d rows: %v", requestID(r), index, err)
+		summary.Summary.Error = err.Error()
+	}
+	summary.Summary.TruncatedCells = scanner.truncatedCells
+
+	b, _ := json.Marshal(summary)
+	writeLine(b)
+	return summary.Summary.Rows
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ---- TRACING ----
+
+const (
+	serviceName = "go-sql-runner"
+
+	// maxSpanStatementLen bounds the db.statement attribute, in bytes, so
+	// huge queries don't bloat exported spans.
+	maxSpanStatementLen = 1024
+)
+
+var tracer = otel.Tracer("github.com/kurohashi/go-sql-runner")
+
+// setupTracing installs the W3C trace-context propagator and, when an OTLP
+// endpoint is configured, an exporting tracer provider. The returned
+// function flushes pending spans.
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	if otlpEndpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(otlpEndpoint))
+	if err != nil {
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", serviceName),
+		)),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// withTracing starts a server span per request, continuing any trace
+// passed in via the traceparent header.
+func withTracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method+" "+r.URL.Path,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+			),
+		)
+		defer span.End()
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		span.SetAttributes(attribute.Int("http.response.status_code", rec.status))
+		if rec.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+	})
+}
+
+// startDBSpan opens the child span that wraps a statement's execution.
+func startDBSpan(ctx context.Context, queryType, sqlQuery string) (context.Context, trace.Span) {
+	stmt := loggedSQL(sqlQuery)
+	if len(stmt) > maxSpanStatementLen {
+		stmt = truncateCell(stmt, maxSpanStatementLen)
+	}
+	return tracer.Start(ctx, "db "+queryType,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", dbDriver),
+			attribute.String("db.operation", queryType),
+			attribute.String("db.statement", stmt),
+		),
+	)
+}
+
+// recordSpanError marks the active span in ctx as failed.
+func recordSpanError(ctx context.Context, err error) {
+	span := trace.SpanFromContext(ctx)
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+func setSpanRowCount(span trace.Span, key string, n int64) {
+	span.SetAttributes(attribute.Int64(key, n))
+}
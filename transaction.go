@@ -0,0 +1,432 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// ---- TRANSACTIONS ----
+
+type TxStatement struct {
+	SQL      string        `json:"sql"`
+	Args     []interface{} `json:"args,omitempty"`
+	ArgTypes []string      `json:"argTypes,omitempty"`
+
+	// ConfirmFullTable lets an UPDATE or DELETE without a WHERE clause
+	// through REQUIRE_WHERE, as on /query.
+	ConfirmFullTable bool `json:"confirmFullTable,omitempty"`
+}
+
+type TransactionRequest struct {
+	Statements []TxStatement `json:"statements"`
+}
+
+// TransactionResponse holds one SelectResponse, ExecResponse or
+// DDLResponse per statement, in order.
+type TransactionResponse struct {
+	Results []interface{} `json:"results"`
+	Meta    *ResponseMeta `json:"meta,omitempty"`
+}
+
+// transactionHandler runs a list of statements in a single transaction on
+// the primary pool, committing only if all of them succeed. The batch is
+// capped at MAX_TX_STATEMENTS and must finish within TX_TIMEOUT, so a
+// client can't hold locks indefinitely.
+func transactionHandler(w http.ResponseWriter, r *http.Request) {
+	var req TransactionRequest
+	if berr := decodeJSONBody(w, r, &req); berr != nil {
+		respondJSON(w, r, berr.status, ErrorResponse{
+			Error: berr.msg,
+		})
+		return
+	}
+
+	if len(req.Statements) == 0 {
+		respondJSON(w, r, http.StatusBadRequest, ErrorResponse{
+			Error: "At least one statement is required",
+		})
+		return
+	}
+	if maxTxStatements > 0 && len(req.Statements) > maxTxStatements {
+		respondJSON(w, r, http.StatusBadRequest, ErrorResponse{
+			Error:   "Too many statements",
+			Message: fmt.Sprintf("transaction has %d statements, limit is %d", len(req.Statements), maxTxStatements),
+		})
+		return
+	}
+
+	// Validate and bind everything up front so a bad statement late in the
+	// batch doesn't cost a round of work that is then rolled back.
+	stmts := make([]boundStatement, len(req.Statements))
+	for i, st := range req.Statements {
+		bound, ok := bindTxStatement(w, r, st, &i)
+		if !ok {
+			return
+		}
+		stmts[i] = bound
+	}
+
+	ctx, span := startDBSpan(r.Context(), "TRANSACTION", fmt.Sprintf("%d statements", len(stmts)))
+	defer span.End()
+	r = r.WithContext(ctx)
+
+	if txTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, txTimeout)
+		defer cancel()
+	}
+
+	if !reserveTransaction() {
+		respondTooManyTransactions(w, r)
+		return
+	}
+	defer releaseTransaction()
+
+	var user string
+	if rlsMode != "" {
+		var err error
+		if user, err = dbIdentityFor(apiKey(r)); err != nil {
+			respondJSON(w, r, http.StatusForbidden, ErrorResponse{
+				Error:   "Forbidden",
+				Message: err.Error(),
+			})
+			return
+		}
+	}
+
+	// Deadlocks and lock wait timeouts say nothing about the statements,
+	// only about what else was running, so the whole transaction is
+	// rolled back and replayed. Any other error is final.
+	rowLimit := rowLimitFor(apiKey(r))
+	var results []interface{}
+	var failed int
+	var err error
+	attempts := 0
+	for {
+		attempts++
+		results, failed, err = runTransaction(ctx, r, user, stmts, rowLimit)
+		if err == nil || !isTransientTxError(err) || attempts > txRetries {
+			break
+		}
+		delay := txRetryDelay(attempts)
+		log.Printf("[%s] transaction attempt %d failed, retrying in %s: %v", requestID(r), attempts, delay, err)
+		if !sleepContext(ctx, delay) {
+			break
+		}
+	}
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		msg := fmt.Sprintf("rolled back after %s", txTimeout)
+		var stmt *int
+		if failed >= 0 {
+			msg += fmt.Sprintf(" while running statement %d", failed)
+			stmt = &failed
+		}
+		respondJSON(w, r, http.StatusGatewayTimeout, ErrorResponse{
+			Error:     "Transaction timed out",
+			Message:   msg,
+			RequestID: requestID(r),
+			Statement: stmt,
+			Code:      codeStatementTimeout,
+		})
+		return
+	}
+	if err != nil {
+		if failed >= 0 {
+			err = fmt.Errorf("statement %d: %w", failed, err)
+		}
+		respondErr(w, r, err)
+		return
+	}
+	respondJSON(w, r, http.StatusOK, TransactionResponse{
+		Results: results,
+		Meta:    &ResponseMeta{Attempts: attempts},
+	})
+}
+
+// runTransaction runs stmts in one transaction and commits it. On error
+// it reports the index of the failing statement, or -1 if the transaction
+// itself failed to begin, take the caller's identity or commit.
+func runTransaction(ctx context.Context, r *http.Request, user string, stmts []boundStatement, rowLimit int) ([]interface{}, int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, -1, err
+	}
+	defer tx.Rollback()
+
+	if rlsMode != "" {
+		if err := applyDBIdentity(ctx, tx, user); err != nil {
+			return nil, -1, err
+		}
+	}
+
+	results := make([]interface{}, 0, len(stmts))
+	for i, st := range stmts {
+		result, err := runTxStatement(ctx, tx, tagQuery(r, st.sql), st.queryType, st.args, rowLimit)
+		if err != nil {
+			return nil, i, err
+		}
+		results = append(results, result)
+	}
+	return results, -1, tx.Commit()
+}
+
+// isTransientTxError reports whether err is a deadlock or lock wait
+// timeout, which a retry of the same transaction may well get past.
+func isTransientTxError(err error) bool {
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) {
+		return myErr.Number == mysqlErrDeadlock || myErr.Number == mysqlErrLockWaitTimeout
+	}
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && (pgErr.Code == pgDeadlockDetected || pgErr.Code == pgLockNotAvailable)
+}
+
+const (
+	mysqlErrLockWaitTimeout = 1205
+	mysqlErrDeadlock        = 1213
+	pgDeadlockDetected      = "40P01"
+	pgLockNotAvailable      = "55P03"
+)
+
+// txRetryDelay is TX_RETRY_BACKOFF doubled for each earlier attempt, with
+// jitter between half and all of it so contending clients don't retry in
+// lockstep.
+func txRetryDelay(attempt int) time.Duration {
+	d := txRetryBackoff << (attempt - 1)
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// sleepContext waits for d, returning false early if ctx ends first.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+type boundStatement struct {
+	sql, queryType string
+	args           []interface{}
+}
+
+// bindTxStatement binds st and checks it against the caller's scope, table
+// allowlist, query windows, REQUIRE_WHERE and, for DDL, X-Confirm-DDL and
+// the DDL rate limit, answering the request and returning false when it
+// may not run. index, when set, is echoed in the error body.
+func bindTxStatement(w http.ResponseWriter, r *http.Request, st TxStatement, index *int) (boundStatement, bool) {
+	sqlQuery := trimStatement(st.SQL)
+	if sqlQuery == "" {
+		respondJSON(w, r, http.StatusBadRequest, ErrorResponse{
+			Error:     "SQL query is required",
+			Statement: index,
+		})
+		return boundStatement{}, false
+	}
+	if !checkSingleStatement(w, r, sqlQuery, index) || !checkComplexity(w, r, sqlQuery, index) {
+		return boundStatement{}, false
+	}
+	sqlQuery, args, err := bindStatement(sqlQuery, st.Args, st.ArgTypes)
+	if err != nil {
+		respondJSON(w, r, http.StatusBadRequest, ErrorResponse{
+			Error:     "Invalid args",
+			Message:   err.Error(),
+			Statement: index,
+		})
+		return boundStatement{}, false
+	}
+	queryType := statementType(sqlQuery)
+	if scope := callerScope(r); !scopeAllows(scope, queryType) {
+		respondJSON(w, r, http.StatusForbidden, ErrorResponse{
+			Error:     "Forbidden",
+			Message:   fmt.Sprintf("%s statements are not permitted with %s scope", queryType, scope),
+			RequestID: requestID(r),
+			Statement: index,
+		})
+		return boundStatement{}, false
+	}
+	if denied := tableRestriction(r, sqlQuery); denied != "" {
+		respondJSON(w, r, http.StatusForbidden, ErrorResponse{
+			Error:     "Forbidden",
+			Message:   fmt.Sprintf("%s is not permitted for this key", denied),
+			RequestID: requestID(r),
+			Statement: index,
+		})
+		return boundStatement{}, false
+	}
+	if !checkQueryWindow(w, r, queryType) || !checkRequireWhere(w, r, sqlQuery, st.ConfirmFullTable, index) ||
+		!checkDDL(w, r, queryType, index) {
+		return boundStatement{}, false
+	}
+	return boundStatement{sql: sqlQuery, queryType: queryType, args: args}, true
+}
+
+func runTxStatement(ctx context.Context, tx queryer, sqlQuery, queryType string, args []interface{}, rowLimit int) (interface{}, error) {
+	switch {
+	case isReadQuery(queryType):
+		rows, err := tx.QueryContext(ctx, sqlQuery, args...)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		colTypes, err := rows.ColumnTypes()
+		if err != nil {
+			return nil, err
+		}
+		if maxColumns > 0 && len(colTypes) > maxColumns {
+			return nil, &resultLimitError{"Too many columns",
+				fmt.Sprintf("result has %d columns, limit is %d", len(colTypes), maxColumns)}
+		}
+		scanner := newRowScanner(colTypes)
+		results := []map[string]interface{}{}
+		truncated := false
+		size := &byteCounter{}
+		sizeEnc := json.NewEncoder(size)
+		for rows.Next() {
+			if rowLimit > 0 && len(results) >= rowLimit {
+				truncated = true
+				break
+			}
+			row, err := scanner.scan(rows)
+			if err != nil {
+				return nil, err
+			}
+			if maxResponseBytes > 0 {
+				_ = sizeEnc.Encode(row)
+				if overByteLimit(size.n) {
+					return nil, &resultLimitError{"Response too large",
+						fmt.Sprintf("result exceeds %d bytes after %d rows; narrow the query", maxResponseBytes, len(results))}
+				}
+			}
+			results = append(results, row)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return SelectResponse{
+			Type:      queryType,
+			Columns:   scanner.columnInfo(),
+			Rows:      results,
+			Count:     len(results),
+			Truncated: truncated,
+
+			TruncatedCells: scanner.truncatedCells,
+		}, nil
+
+	case queryType == "INSERT" || queryType == "UPDATE" || queryType == "DELETE":
+		res, err := tx.ExecContext(ctx, sqlQuery, args...)
+		if err != nil {
+			return nil, err
+		}
+		affected, _ := res.RowsAffected()
+		response := ExecResponse{Type: queryType, AffectedRows: affected}
+		if queryType == "INSERT" {
+			if id, err := res.LastInsertId(); err == nil {
+				response.InsertID = &id
+			}
+		}
+		return response, nil
+
+	default:
+		if _, err := tx.ExecContext(ctx, sqlQuery, args...); err != nil {
+			return nil, err
+		}
+		return DDLResponse{Type: queryType, Status: "executed"}, nil
+	}
+}
+
+// resultLimitError is a read whose result breaks MAX_COLUMNS or
+// MAX_RESPONSE_BYTES. respondErr answers it with 400 and its title.
+type resultLimitError struct {
+	title, msg string
+}
+
+func (e *resultLimitError) Error() string {
+	return e.msg
+}
+
+// openTransactions counts sessions and /transaction batches currently
+// holding a transaction, for MAX_OPEN_TRANSACTIONS.
+var openTransactions atomic.Int64
+
+// reserveTransaction counts a transaction as open, or reports false when
+// MAX_OPEN_TRANSACTIONS are already open. Every true must be matched by a
+// releaseTransaction.
+func reserveTransaction() bool {
+	if n := openTransactions.Add(1); maxOpenTransactions > 0 && n > int64(maxOpenTransactions) {
+		openTransactions.Add(-1)
+		return false
+	}
+	return true
+}
+
+func releaseTransaction() {
+	openTransactions.Add(-1)
+}
+
+// slot is a place among MAX_SESSIONS or MAX_CURSORS, held from the cap
+// check until the session or cursor is added to its map. Taking it in the
+// same critical section as the check keeps concurrent requests from all
+// passing it; until filled, it is counted through *opening.
+type slot struct {
+	mu      *sync.Mutex
+	opening *int
+	filled  bool
+}
+
+// reserveSlot takes a slot unless inUse() (read under mu) plus those
+// already opening reaches limit (0 = unlimited). The caller must defer
+// release, and call fill, with mu held, as it adds the entry.
+func reserveSlot(mu *sync.Mutex, opening *int, inUse func() int, limit int) (*slot, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	if limit > 0 && inUse()+*opening >= limit {
+		return nil, false
+	}
+	*opening++
+	return &slot{mu: mu, opening: opening}, true
+}
+
+func (s *slot) fill() {
+	*s.opening--
+	s.filled = true
+}
+
+// release gives back a slot that was never filled.
+func (s *slot) release() {
+	if s.filled {
+		return
+	}
+	s.mu.Lock()
+	*s.opening--
+	s.mu.Unlock()
+}
+
+// respondTooManyTransactions answers 503 when reserveTransaction failed.
+func respondTooManyTransactions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", "1")
+	respondJSON(w, r, http.StatusServiceUnavailable, ErrorResponse{
+		Error:     "Too many open transactions",
+		Message:   fmt.Sprintf("%d transactions are already open", maxOpenTransactions),
+		RequestID: requestID(r),
+	})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestBindTxStatementRequireWhere(t *testing.T) {
+	saved := requireWhere
+	defer func() { requireWhere = saved }()
+	requireWhere = true
+
+	tests := []struct {
+		st     TxStatement
+		status int // 0 when the statement is bound
+	}{
+		{TxStatement{SQL: "DELETE FROM t WHERE id = ?", Args: []interface{}{1}}, 0},
+		{TxStatement{SQL: "DELETE FROM t"}, http.StatusBadRequest},
+		{TxStatement{SQL: "/**/DELETE FROM t"}, http.StatusBadRequest},
+		{TxStatement{SQL: "UPDATE t SET x = 1"}, http.StatusBadRequest},
+		{TxStatement{SQL: "UPDATE t SET x = 1", ConfirmFullTable: true}, 0},
+		{TxStatement{SQL: "SELECT * FROM t"}, 0},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/transaction", nil)
+		_, ok := bindTxStatement(w, r, tt.st, nil)
+		if ok != (tt.status == 0) || (!ok && w.Code != tt.status) {
+			t.Errorf("bindTxStatement(%q) = %v with status %d, want status %d", tt.st.SQL, ok, w.Code, tt.status)
+		}
+	}
+}
+
+func TestReserveSlot(t *testing.T) {
+	var mu sync.Mutex
+	var opening int
+	open := map[string]bool{"a": true}
+	inUse := func() int { return len(open) }
+
+	first, ok := reserveSlot(&mu, &opening, inUse, 3)
+	if !ok {
+		t.Fatal("first reservation refused with one of three slots in use")
+	}
+	second, ok := reserveSlot(&mu, &opening, inUse, 3)
+	if !ok {
+		t.Fatal("second reservation refused with two of three slots taken")
+	}
+	if _, ok := reserveSlot(&mu, &opening, inUse, 3); ok {
+		t.Fatal("reservation granted with all three slots taken")
+	}
+
+	mu.Lock()
+	open["b"] = true
+	first.fill()
+	mu.Unlock()
+	first.release() // no-op once filled
+	second.release()
+	if opening != 0 {
+		t.Errorf("opening = %d after fill and release, want 0", opening)
+	}
+	if _, ok := reserveSlot(&mu, &opening, inUse, 3); !ok {
+		t.Error("reservation refused after a slot was given back")
+	}
+	if _, ok := reserveSlot(&mu, &opening, inUse, 0); !ok {
+		t.Error("reservation refused with no limit")
+	}
+}
+
+func TestRespondErrResultLimit(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/transaction", nil)
+	respondErr(w, r, fmt.Errorf("statement 1: %w", &resultLimitError{"Too many columns", "result has 9 columns, limit is 8"}))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if body := w.Body.String(); !strings.Contains(body, `"Too many columns"`) || !strings.Contains(body, "statement 1: result has 9 columns") {
+		t.Errorf("body = %s, want the limit's title and message", body)
+	}
+}
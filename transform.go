@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// ---- COLUMN TRANSFORMERS ----
+
+// columnTransform derives the output value of a column from its converted
+// value. It is never called for NULLs.
+type columnTransform func(v interface{}) interface{}
+
+// transformerFactory builds a transform from the options given for a
+// column in COLUMN_TRANSFORMS_FILE.
+type transformerFactory func(opts json.RawMessage) (columnTransform, error)
+
+// transformers maps the "transform" of a COLUMN_TRANSFORMS_FILE entry to
+// the factory that reads the rest of its options.
+var transformers = map[string]transformerFactory{
+	"mask":     newMaskTransform,
+	"truncate": newTruncateTransform,
+	"enum":     newEnumTransform,
+}
+
+// columnTransforms maps lower-cased column names to their transform. The
+// config keys are "table.column" or "column"; like REDACT_COLUMNS, only the
+// column part is matched because result metadata carries no table name.
+var columnTransforms = loadColumnTransforms()
+
+func loadColumnTransforms() map[string]columnTransform {
+	if columnTransformsFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(columnTransformsFile)
+	if err != nil {
+		log.Fatalf("COLUMN_TRANSFORMS_FILE: %v", err)
+	}
+	var specs map[string]json.RawMessage
+	if err := json.Unmarshal(data, &specs); err != nil {
+		log.Fatalf("COLUMN_TRANSFORMS_FILE: %v", err)
+	}
+
+	out := make(map[string]columnTransform, len(specs))
+	for name, raw := range specs {
+		var spec struct {
+			Transform string `json:"transform"`
+		}
+		if err := json.Unmarshal(raw, &spec); err != nil {
+			log.Fatalf("COLUMN_TRANSFORMS_FILE: %s: %v", name, err)
+		}
+		factory, ok := transformers[spec.Transform]
+		if !ok {
+			log.Fatalf("COLUMN_TRANSFORMS_FILE: %s: unknown transform %q", name, spec.Transform)
+		}
+		fn, err := factory(raw)
+		if err != nil {
+			log.Fatalf("COLUMN_TRANSFORMS_FILE: %s: %v", name, err)
+		}
+		if i := strings.LastIndexByte(name, '.'); i >= 0 {
+			name = name[i+1:]
+		}
+		out[strings.ToLower(name)] = fn
+	}
+	return out
+}
+
+// newMaskTransform keeps the first and last "keep" characters (default 2)
+// and replaces the rest with *, e.g. 5551234567 -> 55******67.
+func newMaskTransform(opts json.RawMessage) (columnTransform, error) {
+	cfg := struct {
+		Keep int `json:"keep"`
+	}{Keep: 2}
+	if err := json.Unmarshal(opts, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Keep < 0 {
+		return nil, fmt.Errorf("keep must not be negative")
+	}
+	return func(v interface{}) interface{} {
+		r := []rune(fmt.Sprint(v))
+		if len(r) <= 2*cfg.Keep {
+			return strings.Repeat("*", len(r))
+		}
+		return string(r[:cfg.Keep]) + strings.Repeat("*", len(r)-2*cfg.Keep) + string(r[len(r)-cfg.Keep:])
+	}, nil
+}
+
+// newTruncateTransform cuts values to "length" characters.
+func newTruncateTransform(opts json.RawMessage) (columnTransform, error) {
+	var cfg struct {
+		Length int `json:"length"`
+	}
+	if err := json.Unmarshal(opts, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Length <= 0 {
+		return nil, fmt.Errorf("length must be positive")
+	}
+	return func(v interface{}) interface{} {
+		s, ok := v.(string)
+		if !ok {
+			return v
+		}
+		if r := []rune(s); len(r) > cfg.Length {
+			return string(r[:cfg.Length])
+		}
+		return s
+	}, nil
+}
+
+// newEnumTransform maps values to labels via "values", e.g.
+// {"1": "active", "2": "suspended"}. Unlisted values pass through unless
+// "default" is set.
+func newEnumTransform(opts json.RawMessage) (columnTransform, error) {
+	var cfg struct {
+		Values  map[string]string `json:"values"`
+		Default *string           `json:"default"`
+	}
+	if err := json.Unmarshal(opts, &cfg); err != nil {
+		return nil, err
+	}
+	if len(cfg.Values) == 0 {
+		return nil, fmt.Errorf("values must not be empty")
+	}
+	return func(v interface{}) interface{} {
+		if label, ok := cfg.Values[fmt.Sprint(v)]; ok {
+			return label
+		}
+		if cfg.Default != nil {
+			return *cfg.Default
+		}
+		return v
+	}, nil
+}
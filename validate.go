@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ---- SCHEMA VALIDATION ----
+
+// schemaSnapshot maps each table, lower-cased as "schema.name" and, for
+// tables in the current schema (or search_path), as "name", to its
+// lower-cased columns.
+type schemaSnapshot map[string]map[string]bool
+
+var currentSchema struct {
+	mu       sync.RWMutex
+	snapshot schemaSnapshot
+	loaded   time.Time
+}
+
+// loadSchemaSnapshot reads every table's columns from information_schema.
+func loadSchemaSnapshot(ctx context.Context) (schemaSnapshot, error) {
+	query := `SELECT TABLE_SCHEMA, TABLE_NAME, COLUMN_NAME, TABLE_SCHEMA = DATABASE()
+		FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA NOT IN ('mysql', 'information_schema', 'performance_schema', 'sys')`
+	if dbDriver == driverPostgres {
+		query = `SELECT table_schema, table_name, column_name, table_schema = ANY(current_schemas(false))
+			FROM information_schema.columns
+			WHERE table_schema NOT IN ('pg_catalog', 'information_schema')`
+	}
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snap := schemaSnapshot{}
+	add := func(table, col string) {
+		if snap[table] == nil {
+			snap[table] = map[string]bool{}
+		}
+		snap[table][col] = true
+	}
+	for rows.Next() {
+		var schema, table, col string
+		var current bool
+		if err := rows.Scan(&schema, &table, &col, &current); err != nil {
+			return nil, err
+		}
+		table, col = strings.ToLower(table), strings.ToLower(col)
+		add(strings.ToLower(schema)+"."+table, col)
+		if current {
+			add(table, col)
+		}
+	}
+	return snap, rows.Err()
+}
+
+// refreshSchema replaces the snapshot. A failed refresh keeps the previous
+// one.
+func refreshSchema() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	snap, err := loadSchemaSnapshot(ctx)
+	if err != nil {
+		return err
+	}
+	currentSchema.mu.Lock()
+	currentSchema.snapshot, currentSchema.loaded = snap, time.Now()
+	currentSchema.mu.Unlock()
+	return nil
+}
+
+// refreshSchemaPeriodically reloads the snapshot every
+// SCHEMA_REFRESH_INTERVAL so migrations are picked up.
+func refreshSchemaPeriodically() {
+	for range time.Tick(schemaRefreshInterval) {
+		if err := refreshSchema(); err != nil {
+			log.Printf("schema snapshot refresh failed: %v", err)
+		}
+	}
+}
+
+// aliasStop are the words that can follow a table name without being its
+// alias.
+var aliasStop = map[string]bool{
+	"JOIN": true, "INNER": true, "LEFT": true, "RIGHT": true, "FULL": true,
+	"CROSS": true, "NATURAL": true, "OUTER": true, "STRAIGHT_JOIN": true,
+	"VALUES": true, "SELECT": true, "DEFAULT": true, "PARTITION": true,
+	"USE": true, "FORCE": true, "IGNORE": true, "TABLESAMPLE": true,
+}
+
+// validateAgainstSchema checks the tables a statement references and the
+// columns it names against snap, returning one message per problem.
+// Columns are checked where their table is certain: qualified as
+// table.column or alias.column, an INSERT's column list and the
+// assignments of a single-table UPDATE. Bare columns elsewhere, and
+// columns of subqueries and CTEs, are not checked, so a clean result
+// isn't a guarantee the statement will run.
+func validateAgainstSchema(sql string, snap schemaSnapshot) []string {
+	var problems []string
+	referenced := map[string]bool{}
+	for _, table := range referencedTables(sql) {
+		referenced[table] = true
+		if snap[table] == nil {
+			problems = append(problems, "unknown table "+table)
+		}
+	}
+
+	toks := significant(tokenize(sql))
+	// Where each table name is, and what it is called in the statement.
+	scopes := map[string]string{}
+	names := map[int]int{} // start of a table name -> index past it
+	for i := 1; i < len(toks); i++ {
+		if !(toks[i-1].is("FROM") || toks[i-1].is("JOIN") || toks[i-1].is("INTO") ||
+			toks[i-1].is("UPDATE") || toks[i-1].is("TABLE") || toks[i-1].text == ",") {
+			continue
+		}
+		name, next := readTableName(toks, i)
+		if !referenced[name] || snap[name] == nil || (next < len(toks) && toks[next].text == "(" && !toks[i-1].is("INTO")) {
+			continue
+		}
+		names[i] = next
+		scopes[name] = name
+		if dot := strings.LastIndexByte(name, '.'); dot >= 0 {
+			scopes[name[dot+1:]] = name
+		}
+		alias := next
+		if alias < len(toks) && toks[alias].is("AS") {
+			alias++
+		}
+		if alias < len(toks) && isIdent(toks[alias]) &&
+			!(toks[alias].kind == tokWord && (fromListEnd[strings.ToUpper(toks[alias].text)] || aliasStop[strings.ToUpper(toks[alias].text)])) {
+			scopes[identName(toks[alias])] = name
+		}
+	}
+
+	check := func(qualifier, table, col string) {
+		if !snap[table][strings.ToLower(col)] {
+			problems = append(problems, fmt.Sprintf("unknown column %s.%s", qualifier, col))
+		}
+	}
+
+	for i := 0; i < len(toks); i++ {
+		if next, ok := names[i]; ok {
+			i = next - 1
+			continue
+		}
+		if !isIdent(toks[i]) || (i > 0 && toks[i-1].text == ".") {
+			continue
+		}
+		j := i
+		for j+2 < len(toks) && toks[j+1].text == "." && isIdent(toks[j+2]) {
+			j += 2
+		}
+		if j == i || (j+1 < len(toks) && toks[j+1].text == "(") {
+			continue // not qualified, or a function
+		}
+		var parts []string
+		for k := i; k < j; k += 2 {
+			parts = append(parts, identName(toks[k]))
+		}
+		qualifier := strings.Join(parts, ".")
+		if table, ok := scopes[qualifier]; ok {
+			check(qualifier, table, unquoteIdent(toks[j]))
+		}
+		i = j
+	}
+
+	if len(toks) > 0 && (toks[0].is("INSERT") || toks[0].is("REPLACE")) {
+		for i := range toks {
+			next, ok := names[i]
+			if !ok || next >= len(toks) || toks[next].text != "(" {
+				continue
+			}
+			table, _ := readTableName(toks, i)
+			for k := next + 1; k < len(toks) && toks[k].text != ")"; k++ {
+				if isIdent(toks[k]) {
+					check(table, table, unquoteIdent(toks[k]))
+				}
+			}
+			break
+		}
+	}
+
+	if len(toks) > 1 && toks[0].is("UPDATE") && len(referencedTables(sql)) == 1 {
+		table, _ := readTableName(toks, 1)
+		depth := 0
+		for i := 0; i < len(toks) && snap[table] != nil; i++ {
+			switch {
+			case toks[i].text == "(":
+				depth++
+			case toks[i].text == ")":
+				depth--
+			case depth == 0 && toks[i].is("WHERE"):
+				i = len(toks)
+			case depth == 0 && (toks[i].is("SET") || toks[i].text == ",") &&
+				i+2 < len(toks) && isIdent(toks[i+1]) && toks[i+2].text == "=":
+				check(table, table, unquoteIdent(toks[i+1]))
+			}
+		}
+	}
+	return problems
+}
+
+type ValidateResponse struct {
+	Valid          bool     `json:"valid"`
+	Errors         []string `json:"errors,omitempty"`
+	SchemaLoadedAt string   `json:"schemaLoadedAt"`
+}
+
+// validateHandler checks a statement against the schema snapshot without
+// touching the database, for editors that want fast feedback on typos.
+func validateHandler(w http.ResponseWriter, r *http.Request) {
+	var req QueryRequest
+	if berr := decodeJSONBody(w, r, &req); berr != nil {
+		respondJSON(w, r, berr.status, ErrorResponse{
+			Error: berr.msg,
+		})
+		return
+	}
+
+	sqlQuery := trimStatement(req.SQL)
+	if sqlQuery == "" {
+		respondJSON(w, r, http.StatusBadRequest, ErrorResponse{
+			Error: "SQL query is required",
+		})
+		return
+	}
+	if !checkSingleStatement(w, r, sqlQuery, nil) {
+		return
+	}
+	if denied := tableRestriction(r, sqlQuery); denied != "" {
+		respondJSON(w, r, http.StatusForbidden, ErrorResponse{
+			Error:     "Forbidden",
+			Message:   fmt.Sprintf("%s is not permitted for this key", denied),
+			RequestID: requestID(r),
+		})
+		return
+	}
+
+	switch statementType(sqlQuery) {
+	case "SELECT", "WITH", "INSERT", "REPLACE", "UPDATE", "DELETE":
+	default:
+		respondJSON(w, r, http.StatusBadRequest, ErrorResponse{
+			Error:   "Unsupported statement",
+			Message: "only SELECT, INSERT, REPLACE, UPDATE and DELETE statements can be validated",
+		})
+		return
+	}
+
+	currentSchema.mu.RLock()
+	snap, loaded := currentSchema.snapshot, currentSchema.loaded
+	currentSchema.mu.RUnlock()
+
+	problems := validateAgainstSchema(sqlQuery, snap)
+	respondJSON(w, r, http.StatusOK, ValidateResponse{
+		Valid:          len(problems) == 0,
+		Errors:         problems,
+		SchemaLoadedAt: loaded.UTC().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidateAgainstSchema(t *testing.T) {
+	snap := schemaSnapshot{
+		"a":        {"id": true, "x": true},
+		"shop.a":   {"id": true, "x": true},
+		"b":        {"id": true, "a_id": true},
+		"shop.log": {"msg": true},
+	}
+	tests := []struct {
+		sql  string
+		want []string
+	}{
+		{"SELECT a.x FROM a", nil},
+		{"SELECT A.X FROM A", nil},
+		{"SELECT t.x, u.a_id FROM a AS t JOIN b u ON t.id = u.a_id", nil},
+		{"SELECT shop.a.x FROM shop.a", nil},
+		{"SELECT a.y FROM a", []string{"unknown column a.y"}},
+		{"SELECT t.y FROM a t", []string{"unknown column t.y"}},
+		{"SELECT * FROM secret", []string{"unknown table secret"}},
+		{"SELECT * FROM (secret)", []string{"unknown table secret"}},
+		{"SELECT * FROM a, (secret)", []string{"unknown table secret"}},
+		{"SELECT * FROM a JOIN (b JOIN secret ON 1) ON 1", []string{"unknown table secret"}},
+		{"SELECT * FROM a WHERE id IN (SELECT a_id FROM secret)", []string{"unknown table secret"}},
+		{"SELECT s.y FROM (SELECT x AS y FROM a) s", nil},
+		{"SELECT y FROM a", nil}, // bare columns aren't checked
+		{"SELECT a.x FROM a WHERE json_extract(a.x, '$.y')", nil},
+		{"INSERT INTO a (id, x) VALUES (1, 2)", nil},
+		{"INSERT INTO a (id, y) VALUES (1, 2)", []string{"unknown column a.y"}},
+		{"INSERT INTO shop.log (msg) SELECT x FROM a", nil},
+		{"UPDATE a SET x = 1, y = 2 WHERE id = 1", []string{"unknown column a.y"}},
+		{"UPDATE a SET x = 1 WHERE y = 2", nil},
+		{"UPDATE secret SET x = 1", []string{"unknown table secret"}},
+		{"DELETE FROM b WHERE b.z = 1", []string{"unknown column b.z"}},
+	}
+	for _, tt := range tests {
+		if got := validateAgainstSchema(tt.sql, snap); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("validateAgainstSchema(%q) = %q, want %q", tt.sql, got, tt.want)
+		}
+	}
+}
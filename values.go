@@ -0,0 +1,452 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-sql-driver/mysql"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// ---- VALUE CONVERSION ----
+
+// Layouts the MySQL text protocol uses for temporal values when the DSN
+// does not set parseTime=true.
+var temporalLayouts = []string{
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+}
+
+// textLocation is the zone zone-less DATETIME/TIMESTAMP text is read in:
+// the DSN's loc= on MySQL, which is also what the driver uses with
+// parseTime=true, so both settings give the same instant. Postgres hands
+// over time.Time values and never needs it.
+var textLocation = dsnLocation()
+
+func dsnLocation() *time.Location {
+	if dbDriver != driverMySQL {
+		return time.UTC
+	}
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil || cfg.Loc == nil {
+		return time.UTC
+	}
+	return cfg.Loc
+}
+
+const redactedMarker = "***"
+
+// columnSet indexes column names case-insensitively. Result metadata
+// doesn't say which table a column came from, so a "table.column" entry
+// matches that column name in any result; this errs on the side of hiding.
+func columnSet(names []string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		if i := strings.LastIndexByte(name, '.'); i >= 0 {
+			name = name[i+1:]
+		}
+		set[strings.ToLower(name)] = true
+	}
+	return set
+}
+
+// isRedacted reports whether a result column is named in REDACT_COLUMNS.
+// It sees the name the statement gave the column, not the table column
+// behind it, so an alias gets around it.
+func isRedacted(column string) bool {
+	return redactedColumns[strings.ToLower(column)]
+}
+
+// rowScanner reads result rows into maps keyed by column name, applying
+// redaction, value conversion and column transforms. Values that can't be
+// represented are emitted as null, with the reason kept as a note on their
+// column.
+type rowScanner struct {
+	columns  []string
+	colTypes []*sql.ColumnType
+	notes    []string
+	keep     []bool // columns to emit; nil keeps all
+	binary   string // encoding of binary columns, binaryBase64 or binaryHex
+	pipeline pipeline
+
+	// charset, when set, is what text columns are decoded from.
+	charset encoding.Encoding
+
+	// typed wraps every cell as a typedCell (?typed=true).
+	typed bool
+
+	// truncatedCells is set once a cell has been cut to MAX_CELL_BYTES.
+	truncatedCells bool
+}
+
+// typedCell is a value tagged with its column's database type, for
+// clients that handle cells without the columns array.
+type typedCell struct {
+	V interface{} `json:"v"`
+	T string      `json:"t"`
+}
+
+func newRowScanner(colTypes []*sql.ColumnType) *rowScanner {
+	return &rowScanner{
+		columns:  columnNames(colTypes),
+		colTypes: colTypes,
+		notes:    make([]string, len(colTypes)),
+		binary:   binaryBase64,
+	}
+}
+
+func (s *rowScanner) scan(rows *sql.Rows) (map[string]interface{}, error) {
+	values := make([]interface{}, len(s.columns))
+	valuePtrs := make([]interface{}, len(s.columns))
+
+	for i := range values {
+		valuePtrs[i] = &values[i]
+	}
+
+	if err := rows.Scan(valuePtrs...); err != nil {
+		return nil, err
+	}
+
+	row := map[string]interface{}{}
+	for i, col := range s.columns {
+		if s.keep != nil && !s.keep[i] {
+			continue
+		}
+		if isRedacted(col) {
+			row[col] = s.cell(i, redactedMarker)
+			continue
+		}
+		if b, ok := values[i].([]byte); ok && s.charset != nil && textTypes[s.colTypes[i].DatabaseTypeName()] {
+			if utf, err := s.charset.NewDecoder().Bytes(b); err == nil {
+				values[i] = utf
+			}
+		}
+		v, err := convertValue(s.colTypes[i], values[i], s.binary)
+		if err != nil && s.notes[i] == "" {
+			s.notes[i] = err.Error()
+		}
+		if fn := columnTransforms[strings.ToLower(col)]; fn != nil && v != nil {
+			v = fn(v)
+		}
+		if str, ok := v.(string); ok && maxCellBytes > 0 && len(str) > maxCellBytes {
+			v = truncateCell(str, maxCellBytes)
+			s.truncatedCells = true
+		}
+		row[col] = s.cell(i, v)
+	}
+	if s.pipeline != nil {
+		row = s.pipeline.row(row)
+	}
+	return row, nil
+}
+
+// cell returns v as it is emitted for column i.
+func (s *rowScanner) cell(i int, v interface{}) interface{} {
+	if !s.typed {
+		return v
+	}
+	return typedCell{V: v, T: s.colTypes[i].DatabaseTypeName()}
+}
+
+// project restricts the emitted columns to fields, in result order. With
+// a pipeline, fields name the columns it outputs, i.e. after any rename;
+// the columns a flattenJson stage creates vary by row and can't be
+// projected, but the column it flattens can. It fails naming any field
+// the result doesn't have. Set the pipeline first.
+func (s *rowScanner) project(fields []string) error {
+	want := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		want[f] = true
+	}
+	keep := make([]bool, len(s.columns))
+	for i, col := range s.columns {
+		name := col
+		if s.pipeline != nil {
+			if out := s.pipeline.columns([]ColumnInfo{{Name: col}}); len(out) == 1 {
+				name = out[0].Name
+			}
+		}
+		if want[name] {
+			keep[i] = true
+			delete(want, name)
+		}
+	}
+	if len(want) > 0 {
+		var missing []string
+		for _, f := range fields {
+			if want[f] {
+				missing = append(missing, f)
+			}
+		}
+		return fmt.Errorf("unknown fields: %s", strings.Join(missing, ", "))
+	}
+	s.keep = keep
+	return nil
+}
+
+// outputTypes returns the types of the emitted columns.
+func (s *rowScanner) outputTypes() []*sql.ColumnType {
+	if s.keep == nil {
+		return s.colTypes
+	}
+	var out []*sql.ColumnType
+	for i, ct := range s.colTypes {
+		if s.keep[i] {
+			out = append(out, ct)
+		}
+	}
+	return out
+}
+
+// columnInfo describes the emitted columns, including any notes recorded
+// by the rows scanned so far.
+func (s *rowScanner) columnInfo() []ColumnInfo {
+	info := []ColumnInfo{}
+	for i, ct := range s.colTypes {
+		if s.keep != nil && !s.keep[i] {
+			continue
+		}
+		ci := describeColumn(ct)
+		ci.Note = s.notes[i]
+		info = append(info, ci)
+	}
+	if s.pipeline != nil {
+		info = s.pipeline.columns(info)
+	}
+	return info
+}
+
+// Encodings for binary column values, chosen per request with ?binary=.
+const (
+	binaryBase64 = "base64"
+	binaryHex    = "hex"
+)
+
+// binaryTypes are the column types whose values are bytes, not text.
+var binaryTypes = map[string]bool{
+	"BINARY": true, "VARBINARY": true, "BYTEA": true,
+	"TINYBLOB": true, "BLOB": true, "MEDIUMBLOB": true, "LONGBLOB": true,
+}
+
+// textTypes are the column types a request's charset applies to.
+var textTypes = map[string]bool{
+	"CHAR": true, "VARCHAR": true, "TINYTEXT": true, "TEXT": true, "MEDIUMTEXT": true,
+	"LONGTEXT": true, "ENUM": true, "SET": true, "BPCHAR": true, "NAME": true,
+}
+
+// sourceCharset looks up a charset by its WHATWG name or label (latin1,
+// windows-1251, shift_jis, gbk, euc-kr, ...). "" means none.
+func sourceCharset(name string) (encoding.Encoding, error) {
+	if name == "" {
+		return nil, nil
+	}
+	enc, err := htmlindex.Get(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown charset %q", name)
+	}
+	return enc, nil
+}
+
+// convertValue turns a scanned driver value into its JSON representation
+// based on the column's database type, encoding binary columns as binary
+// says. It returns an error, along with a null value, when the raw value
+// has no faithful JSON form.
+func convertValue(ct *sql.ColumnType, v interface{}, binary string) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	switch dbType := ct.DatabaseTypeName(); {
+	case dbType == "DATE", dbType == "DATETIME", dbType == "TIMESTAMP", dbType == "TIMESTAMPTZ":
+		return formatTemporal(dbType, v), nil
+
+	case dbType == "TIME", dbType == "TIMETZ":
+		return formatTimeOfDay(v), nil
+
+	case binaryTypes[dbType]:
+		if b, ok := v.([]byte); ok {
+			if binary == binaryHex {
+				return hex.EncodeToString(b), nil
+			}
+			return base64.StdEncoding.EncodeToString(b), nil
+		}
+
+	case dbType == "GEOMETRY":
+		if b, ok := v.([]byte); ok {
+			wkt, err := mysqlGeometryToWKT(b)
+			if err != nil {
+				return nil, fmt.Errorf("GEOMETRY value could not be converted to WKT: %w", err)
+			}
+			return wkt, nil
+		}
+
+	case dbType == "BIT":
+		if b, ok := v.([]byte); ok && len(b) <= 8 {
+			var n uint64
+			for _, c := range b {
+				n = n<<8 | uint64(c)
+			}
+			return n, nil
+		}
+	}
+
+	if b, ok := v.([]byte); ok {
+		if !utf8.Valid(b) {
+			return nil, fmt.Errorf("%s value is not valid UTF-8 text and was omitted", ct.DatabaseTypeName())
+		}
+		return string(b), nil
+	}
+	return v, nil
+}
+
+// formatTemporal emits DATETIME/TIMESTAMP values as RFC3339 and DATE values
+// as an RFC3339 full-date (YYYY-MM-DD), whether the driver handed us a
+// time.Time (parseTime=true) or raw bytes, shifted to OUTPUT_TIMEZONE when
+// set. Raw bytes are read in the DSN's loc=, as the driver would read them.
+// Zero dates are rendered per ZERO_DATE.
+func formatTemporal(dbType string, v interface{}) interface{} {
+	var t time.Time
+
+	switch val := v.(type) {
+	case time.Time:
+		t = val
+	case []byte:
+		s := string(val)
+		if strings.HasPrefix(s, "0000-00-00") {
+			return zeroDate(dbType)
+		}
+		parsed, ok := parseTemporal(s)
+		if !ok {
+			return s
+		}
+		t = parsed
+	default:
+		return v
+	}
+
+	if t.IsZero() {
+		return zeroDate(dbType)
+	}
+	if dbType == "DATE" {
+		return t.Format(time.DateOnly)
+	}
+	if outputTimezone != nil {
+		t = t.In(outputTimezone)
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+// zeroDate renders MySQL's zero date, which parseTime=true turns into
+// time.Time{} and which otherwise arrives as "0000-00-00...".
+func zeroDate(dbType string) interface{} {
+	switch zeroDateMode {
+	case zeroDateString:
+		if dbType == "DATE" {
+			return "0000-00-00"
+		}
+		return "0000-00-00 00:00:00"
+	case zeroDateSentinel:
+		return zeroDateSentinelValue
+	}
+	return nil
+}
+
+// formatTimeOfDay emits TIME values as HH:MM:SS with the fraction, if
+// any, stripped of trailing zeros, whichever protocol or driver setting
+// they came through. MySQL TIMEs outside a day (down to -838:59:59 and up
+// to 838:59:59) keep that form; a TIMETZ keeps its offset.
+func formatTimeOfDay(v interface{}) interface{} {
+	var s string
+	switch val := v.(type) {
+	case []byte:
+		s = string(val)
+	case string:
+		s = val
+	case time.Time:
+		return val.Format("15:04:05.999999999")
+	default:
+		return v
+	}
+	clock, frac, ok := strings.Cut(s, ".")
+	if !ok {
+		return s
+	}
+	digits := strings.IndexFunc(frac, func(r rune) bool { return r < '0' || r > '9' })
+	if digits < 0 {
+		digits = len(frac)
+	}
+	if fraction := strings.TrimRight(frac[:digits], "0"); fraction != "" {
+		clock += "." + fraction
+	}
+	return clock + frac[digits:]
+}
+
+// parseTemporal reads a zone-less text value in textLocation.
+func parseTemporal(s string) (time.Time, bool) {
+	for _, layout := range temporalLayouts {
+		if t, err := time.ParseInLocation(layout, s, textLocation); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func columnNames(colTypes []*sql.ColumnType) []string {
+	names := make([]string, len(colTypes))
+	for i, ct := range colTypes {
+		names[i] = ct.Name()
+	}
+	return names
+}
+
+// describeColumn collects the metadata the driver exposes for a column.
+func describeColumn(ct *sql.ColumnType) ColumnInfo {
+	info := ColumnInfo{
+		Name: ct.Name(),
+		Type: ct.DatabaseTypeName(),
+	}
+	if nullable, ok := ct.Nullable(); ok {
+		info.Nullable = &nullable
+	}
+	if length, ok := ct.Length(); ok {
+		info.Length = &length
+	}
+	if st := ct.ScanType(); st != nil {
+		info.ScanType = st.String()
+	}
+	return info
+}
+
+// byteCounter is an io.Writer that only counts, used to measure encoded
+// rows against MAX_RESPONSE_BYTES without keeping them.
+type byteCounter struct {
+	n int64
+}
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+// overByteLimit reports whether n bytes exceed MAX_RESPONSE_BYTES.
+func overByteLimit(n int64) bool {
+	return maxResponseBytes > 0 && n > int64(maxResponseBytes)
+}
+
+// cellTruncatedMarker ends a cell cut short by MAX_CELL_BYTES.
+const cellTruncatedMarker = "…"
+
+// truncateCell cuts s to at most n bytes without splitting a UTF-8
+// sequence, and marks it as cut.
+func truncateCell(s string, n int) string {
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + cellTruncatedMarker
+}
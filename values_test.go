@@ -0,0 +1,45 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIsRedacted(t *testing.T) {
+	saved := redactedColumns
+	defer func() { redactedColumns = saved }()
+	redactedColumns = columnSet([]string{"users.password", "SSN"})
+
+	tests := []struct {
+		column string
+		want   bool
+	}{
+		{"password", true},
+		{"Password", true},
+		{"ssn", true},
+		{"password_hint", false},
+		{"users", false},
+		{"p", false}, // an alias: REDACT_COLUMNS sees result names only
+	}
+	for _, tt := range tests {
+		if got := isRedacted(tt.column); got != tt.want {
+			t.Errorf("isRedacted(%q) = %v, want %v", tt.column, got, tt.want)
+		}
+	}
+}
+
+func TestProjectAfterRename(t *testing.T) {
+	s := &rowScanner{
+		columns:  []string{"nm", "id", "attrs"},
+		pipeline: pipeline{renameStage{"nm": "name"}, flattenJSONStage{column: "attrs", prefix: "attrs."}},
+	}
+	if err := s.project([]string{"name", "attrs"}); err != nil {
+		t.Fatalf("project(name, attrs) = %v", err)
+	}
+	if want := []bool{true, false, true}; !reflect.DeepEqual(s.keep, want) {
+		t.Errorf("keep = %v, want %v", s.keep, want)
+	}
+	if err := s.project([]string{"nm"}); err == nil {
+		t.Error("project(nm) succeeded, but nm is renamed to name")
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ---- WEBHOOK DELIVERY ----
+
+var webhookClient = &http.Client{
+	Timeout: 10 * time.Second,
+	// A redirect could point anywhere, bypassing the host allowlist.
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// checkCallbackURL only accepts http(s) URLs whose host is listed in
+// WEBHOOK_ALLOWED_HOSTS, so callbacks can't be used to reach internal
+// services.
+func checkCallbackURL(raw string) error {
+	if len(webhookAllowedHosts) == 0 {
+		return fmt.Errorf("callbacks are not enabled on this server")
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("callbackUrl must be an absolute http(s) URL")
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range webhookAllowedHosts {
+		if host == strings.ToLower(allowed) {
+			return nil
+		}
+	}
+	return fmt.Errorf("host %q is not in the callback allowlist", host)
+}
+
+// startWebhookJob answers 202 with a job ID and runs req in the background
+// through the usual /query pipeline, then POSTs the response it produced
+// to req.CallbackURL. At most MAX_ASYNC_JOBS run or await delivery at
+// once, and the response, held in memory until delivered, is capped at
+// MAX_RESPONSE_BYTES. The job gets its own request ID (the job ID) and is
+// not tied to the client's connection; /query/async/{id} tracks it.
+func startWebhookJob(w http.ResponseWriter, r *http.Request, req QueryRequest) {
+	if err := checkCallbackURL(req.CallbackURL); err != nil {
+		respondJSON(w, r, http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid callbackUrl",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	callback := req.CallbackURL
+	req.CallbackURL = ""
+	body, err := json.Marshal(req)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	if !reserveJob() {
+		w.Header().Set("Retry-After", "1")
+		respondJSON(w, r, http.StatusServiceUnavailable, ErrorResponse{
+			Error:     "Too many async jobs",
+			Message:   fmt.Sprintf("%d jobs are already running", maxAsyncJobs),
+			RequestID: requestID(r),
+		})
+		return
+	}
+
+	jobID := newRequestID()
+	info := &requestInfo{id: jobID, clientIP: requestInfoFrom(r).clientIP}
+	ctx, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+	ctx = context.WithValue(ctx, requestInfoKey, info)
+	job := registerJob(jobID, apiKey(r), cancel)
+
+	jobReq := r.Clone(ctx)
+	jobReq.Body = io.NopCloser(bytes.NewReader(body))
+	jobReq.ContentLength = int64(len(body))
+	jobReq.Header.Del("Content-Encoding")
+
+	go func() {
+		defer releaseJob()
+		rec := &bufferedResponse{header: http.Header{}, limit: maxResponseBytes}
+		observeQuery(withAdmission(withBreaker(queryHandler)))(rec, jobReq)
+		if rec.overflowed {
+			rec = &bufferedResponse{header: http.Header{}}
+			respondJSON(rec, jobReq, http.StatusBadRequest, ErrorResponse{
+				Error:     "Response too large",
+				Message:   fmt.Sprintf("result exceeds %d bytes; narrow the query", maxResponseBytes),
+				RequestID: jobID,
+			})
+		}
+		if job.finish(jobID) {
+			log.Printf("[%s] job cancelled, no webhook sent", jobID)
+			return
+		}
+		deliverWebhook(jobID, callback, rec)
+	}()
+
+	respondJSON(w, r, http.StatusAccepted, JobResponse{JobID: jobID, Status: jobRunning})
+}
+
+// deliverWebhook POSTs a job's response to its callback, retrying with
+// exponential backoff until a 2xx or WEBHOOK_RETRIES retries have failed.
+func deliverWebhook(jobID, callback string, rec *bufferedResponse) {
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		err := postWebhook(jobID, callback, rec)
+		if err == nil {
+			log.Printf("[%s] webhook delivered to %s", jobID, callback)
+			return
+		}
+		if attempt >= webhookRetries {
+			log.Printf("[%s] webhook delivery to %s failed, giving up: %v", jobID, callback, err)
+			return
+		}
+		log.Printf("[%s] webhook delivery to %s failed, retrying in %s: %v", jobID, callback, backoff, err)
+		time.Sleep(backoff)
+		backoff = min(2*backoff, time.Minute)
+	}
+}
+
+func postWebhook(jobID, callback string, rec *bufferedResponse) error {
+	req, err := http.NewRequest(http.MethodPost, callback, bytes.NewReader(rec.body.Bytes()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", rec.header.Get("Content-Type"))
+	req.Header.Set("X-Job-ID", jobID)
+	req.Header.Set("X-Result-Status", strconv.Itoa(rec.status))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("callback answered %s", resp.Status)
+	}
+	return nil
+}
+
+// bufferedResponse is a ResponseWriter that keeps the whole response in
+// memory. With a limit, writes past that many bytes fail and set
+// overflowed.
+type bufferedResponse struct {
+	header     http.Header
+	status     int
+	body       bytes.Buffer
+	limit      int
+	overflowed bool
+}
+
+var errResponseTooLarge = errors.New("response exceeds MAX_RESPONSE_BYTES")
+
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	if b.limit > 0 && b.body.Len()+len(p) > b.limit {
+		b.overflowed = true
+		return 0, errResponseTooLarge
+	}
+	return b.body.Write(p)
+}
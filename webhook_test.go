@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestBufferedResponseLimit(t *testing.T) {
+	b := &bufferedResponse{header: http.Header{}, limit: 8}
+	if _, err := b.Write([]byte("12345")); err != nil {
+		t.Fatalf("write within the limit: %v", err)
+	}
+	if _, err := b.Write([]byte("6789")); !errors.Is(err, errResponseTooLarge) {
+		t.Errorf("write past the limit = %v, want errResponseTooLarge", err)
+	}
+	if !b.overflowed || b.body.String() != "12345" {
+		t.Errorf("overflowed = %v, body = %q; want true, \"12345\"", b.overflowed, b.body.String())
+	}
+
+	unlimited := &bufferedResponse{header: http.Header{}}
+	if _, err := unlimited.Write(make([]byte, 1<<16)); err != nil {
+		t.Errorf("write without a limit: %v", err)
+	}
+}
+
+func TestReserveJob(t *testing.T) {
+	saved := maxAsyncJobs
+	defer func() { maxAsyncJobs = saved; activeJobs.Store(0) }()
+	maxAsyncJobs = 2
+	activeJobs.Store(0)
+
+	if !reserveJob() || !reserveJob() {
+		t.Fatal("reservation refused below MAX_ASYNC_JOBS")
+	}
+	if reserveJob() {
+		t.Fatal("reservation granted past MAX_ASYNC_JOBS")
+	}
+	releaseJob()
+	if !reserveJob() {
+		t.Error("reservation refused after a job finished")
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ---- QUERY WINDOWS ----
+
+// timeWindow is a daily span in minutes since midnight. A window whose end
+// is before its start wraps past midnight (22:00-06:00).
+type timeWindow struct {
+	start, end int
+}
+
+func (tw timeWindow) contains(minute int) bool {
+	if tw.start <= tw.end {
+		return minute >= tw.start && minute < tw.end
+	}
+	return minute >= tw.start || minute < tw.end
+}
+
+var queryWindows = parseWindows(queryWindowSpecs)
+
+func parseWindows(specs []string) []timeWindow {
+	windows := make([]timeWindow, 0, len(specs))
+	for _, spec := range specs {
+		from, to, ok := strings.Cut(spec, "-")
+		start, err1 := time.Parse("15:04", strings.TrimSpace(from))
+		end, err2 := time.Parse("15:04", strings.TrimSpace(to))
+		if !ok || err1 != nil || err2 != nil {
+			log.Fatalf("invalid QUERY_WINDOWS entry %q, want HH:MM-HH:MM", spec)
+		}
+		tw := timeWindow{
+			start: start.Hour()*60 + start.Minute(),
+			end:   end.Hour()*60 + end.Minute(),
+		}
+		// It would match no minute at all.
+		if tw.start == tw.end {
+			log.Fatalf("invalid QUERY_WINDOWS entry %q: start and end are the same", spec)
+		}
+		windows = append(windows, tw)
+	}
+	return windows
+}
+
+// windowRestricted reports whether QUERY_WINDOWS applies to this caller
+// and statement type: QUERY_WINDOW_TYPES and QUERY_WINDOW_KEYS narrow it,
+// and each matches everything when empty.
+func windowRestricted(r *http.Request, queryType string) bool {
+	if len(queryWindows) == 0 {
+		return false
+	}
+	if len(queryWindowTypes) > 0 && !slices.ContainsFunc(queryWindowTypes, func(t string) bool {
+		return strings.EqualFold(t, queryType)
+	}) {
+		return false
+	}
+	if len(queryWindowKeys) > 0 && !slices.Contains(queryWindowKeys, apiKey(r)) {
+		return false
+	}
+	return true
+}
+
+// nextWindow returns zero when now falls in an allowed window, otherwise
+// the start of the next one.
+func nextWindow(now time.Time) time.Time {
+	now = now.In(queryWindowTZ)
+	minute := now.Hour()*60 + now.Minute()
+
+	var next time.Time
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, queryWindowTZ)
+	for _, tw := range queryWindows {
+		if tw.contains(minute) {
+			return time.Time{}
+		}
+		start := midnight.Add(time.Duration(tw.start) * time.Minute)
+		if !start.After(now) {
+			start = start.AddDate(0, 0, 1)
+		}
+		if next.IsZero() || start.Before(next) {
+			next = start
+		}
+	}
+	return next
+}
+
+// checkQueryWindow answers 403 and returns false when the statement is
+// outside its allowed windows.
+func checkQueryWindow(w http.ResponseWriter, r *http.Request, queryType string) bool {
+	if !windowRestricted(r, queryType) {
+		return true
+	}
+	next := nextWindow(time.Now())
+	if next.IsZero() {
+		return true
+	}
+	respondJSON(w, r, http.StatusForbidden, ErrorResponse{
+		Error:     "Outside allowed query window",
+		Message:   fmt.Sprintf("%s statements are allowed from %s", queryType, next.Format(time.RFC3339)),
+		RequestID: requestID(r),
+	})
+	return false
+}
@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestTimeWindowContains(t *testing.T) {
+	tests := []struct {
+		spec   string
+		minute int
+		want   bool
+	}{
+		{"09:00-17:00", 9 * 60, true},
+		{"09:00-17:00", 17*60 - 1, true},
+		{"09:00-17:00", 17 * 60, false},
+		{"09:00-17:00", 8 * 60, false},
+		{"22:00-06:00", 23 * 60, true},
+		{"22:00-06:00", 5 * 60, true},
+		{"22:00-06:00", 12 * 60, false},
+	}
+	for _, tt := range tests {
+		if got := parseWindows([]string{tt.spec})[0].contains(tt.minute); got != tt.want {
+			t.Errorf("%s contains minute %d = %v, want %v", tt.spec, tt.minute, got, tt.want)
+		}
+	}
+}